@@ -3,10 +3,12 @@ package container
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"im/iface"
 	"im/logger"
+	"im/naming"
 	"im/tcp"
 	"os"
 	"os/signal"
@@ -44,6 +46,7 @@ type Container struct {
 	srvclients map[string]iface.IClientMap
 	selector   iface.Selector
 	dialer     iface.IDialer
+	tlsConfig  *tls.Config
 	deps       map[string]struct{}
 }
 
@@ -84,6 +87,11 @@ func SetDialer(dialer iface.IDialer) {
 	c.dialer = dialer
 }
 
+// SetTLSConfig 设置与依赖服务之间连接的tls配置
+func SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
 // 设置selector
 func SetSelector(selector iface.Selector) {
 	c.selector = selector
@@ -121,12 +129,18 @@ func Start() error {
 		}(serive)
 	}
 
-	//3.服务注册
-	if c.Srv.PublicAddress() != "" && c.Srv.PublicPort() != 0 {
-		err := c.Name.Register(c.Srv)
+	//3.服务注册，unix socket服务没有端口
+	for _, service := range registrations() {
+		if service.PublicAddress() == "" || (service.PublicPort() == 0 && service.GetProtocol() != naming.ProtocolUnix && service.GetProtocol() != naming.ProtocolPipe) {
+			continue
+		}
+		err := c.Name.Register(service)
 		if err != nil {
 			log.Warn(err)
 		}
+		if hr, ok := c.Name.(iface.HealthReporter); ok && hr.TTL() > 0 {
+			go keepalive(hr, service)
+		}
 	}
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
@@ -135,6 +149,35 @@ func Start() error {
 	return shutdown()
 }
 
+// keepalive 在TTL内定时上报服务存活，容器关闭后停止。
+// 启动时注册失败或者注册中心丢失了检查时重新注册
+func keepalive(hr iface.HealthReporter, service iface.ServiceRegistration) {
+	log := log.WithField("func", "keepalive").WithField("id", service.ServiceID())
+	ticker := time.NewTicker(hr.TTL() / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadUint32(&c.state) != stateStarted {
+			return
+		}
+		err := hr.UpdateTTL(service.ServiceID())
+		if err == iface.ErrNotRegistered {
+			log.Info("service is not registered, register again")
+			err = c.Name.Register(service)
+		}
+		if err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+// registrations 返回需要注册的服务，server在多个地址上提供服务时注册其中的每一个
+func registrations() []iface.ServiceRegistration {
+	if mr, ok := c.Srv.(iface.IMultiRegistration); ok {
+		return mr.Registrations()
+	}
+	return []iface.ServiceRegistration{c.Srv}
+}
+
 func shutdown() error {
 	if !atomic.CompareAndSwapUint32(&c.state, stateStarted, stateClosed) {
 		return errors.New("has closed")
@@ -149,10 +192,15 @@ func shutdown() error {
 	}
 
 	//从注册中心销毁服务
-	err = c.Name.Deregister(c.Srv.ServiceID())
-	if err != nil {
-		log.Warn(err)
-		return err
+	var derr error
+	for _, service := range registrations() {
+		if err := c.Name.Deregister(service.ServiceID()); err != nil {
+			log.Warn(err)
+			derr = err
+		}
+	}
+	if derr != nil {
+		return derr
 	}
 	//退订服务变更
 	for dep := range c.deps {
@@ -298,16 +346,29 @@ func buildClient(clients iface.IClientMap, service iface.ServiceRegistration) (i
 	if _, ok := clients.Get(id); ok {
 		return nil, nil
 	}
-	//2.服务之间只能用tcp
-	if service.GetProtocol() != string(wire.ProtocolTCP) {
+	//2.服务之间只能用tcp、unix socket或进程内的pipe
+	switch service.GetProtocol() {
+	case string(wire.ProtocolTCP), naming.ProtocolUnix, naming.ProtocolPipe:
+	default:
 		return nil, fmt.Errorf("unexpected service Protocol: %s", service.GetProtocol())
 	}
+	// 其它主机上的unix socket服务无法连接
+	if !naming.Reachable(service) {
+		return nil, fmt.Errorf("service %s listens on a unix socket of another host", id)
+	}
 
 	// // 3. 构建客户端并建立连接
 	cli := tcp.NewClientWithProps(id, name, meta, tcp.ClientOptions{
 		Heartbeat: time.Minute,
 		ReadWait:  time.Minute * 3,
 		WriteWait: time.Second * 10,
+		TLSConfig: c.tlsConfig,
+		// 短暂断开时重连，重试失败后由readloop移除，等待服务发现重新建立
+		Reconnect:  true,
+		MaxRetries: 5,
+		OnStateChange: func(state iface.ConnState, err error) {
+			log.Infof("client %s of %s is %s - %v", id, name, state, err)
+		},
 	})
 	if c.dialer == nil {
 		return nil, fmt.Errorf("dialer is nil")
@@ -344,6 +405,12 @@ func readloop(cli iface.IClient) error {
 			return err
 		}
 
+		if frame.GetOpCode() == iface.OpPong {
+			if rt, ok := cli.(iface.IRoundTrip); ok {
+				clientRTT.WithLabelValues(cli.ServiceName(), cli.ServiceID()).Set(rt.RTT().Seconds())
+			}
+			continue
+		}
 		if frame.GetOpCode() != iface.OpBinary {
 			continue
 		}
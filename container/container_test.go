@@ -0,0 +1,46 @@
+package container
+
+import (
+	"im/iface"
+	"im/naming"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ttlNaming 注册之前UpdateTTL返回ErrNotRegistered
+type ttlNaming struct {
+	iface.Naming
+	registered int32
+}
+
+func (n *ttlNaming) Register(iface.ServiceRegistration) error {
+	atomic.AddInt32(&n.registered, 1)
+	return nil
+}
+
+func (n *ttlNaming) TTL() time.Duration { return time.Millisecond * 30 }
+
+func (n *ttlNaming) UpdateTTL(string) error {
+	if atomic.LoadInt32(&n.registered) == 0 {
+		return iface.ErrNotRegistered
+	}
+	return nil
+}
+
+func TestKeepaliveRegisterAgain(t *testing.T) {
+	ns := &ttlNaming{}
+	c.Name = ns
+	atomic.StoreUint32(&c.state, stateStarted)
+	defer atomic.StoreUint32(&c.state, stateUninitialized)
+
+	go keepalive(ns, &naming.DefaultService{Id: "chat01", Name: "chat"})
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&ns.registered) > 0
+	}, time.Second, time.Millisecond*10)
+	// 注册之后不再重复注册
+	time.Sleep(ns.TTL())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ns.registered))
+}
@@ -10,3 +10,9 @@ var messageOutFlowBytes = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name:      "message_out_flow_bytes",
 	Help:      "网关下发的消息字节数",
 }, []string{"command"})
+
+var clientRTT = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "kim",
+	Name:      "service_client_rtt_seconds",
+	Help:      "与依赖服务之间心跳的往返时间",
+}, []string{"service", "id"})
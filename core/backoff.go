@@ -0,0 +1,39 @@
+package core
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoff 默认的重试间隔
+var DefaultBackoff = Backoff{Min: time.Second, Max: time.Second * 30}
+
+// Backoff 指数退避，每次重试的间隔翻倍，不超过Max，并加入随机抖动避免同时重连
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Duration 第attempt次(从0开始)重试之前等待的时间
+func (b Backoff) Duration(attempt int) time.Duration {
+	min, max := b.Min, b.Max
+	if min <= 0 {
+		min = DefaultBackoff.Min
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	// 在[d/2, d)之间取值
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
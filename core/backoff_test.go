@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		max     time.Duration //取值在[max/2, max)之间
+	}{
+		{"first", Backoff{Min: time.Second, Max: time.Second * 30}, 0, time.Second},
+		{"double", Backoff{Min: time.Second, Max: time.Second * 30}, 1, time.Second * 2},
+		{"grow", Backoff{Min: time.Second, Max: time.Second * 30}, 4, time.Second * 16},
+		{"max", Backoff{Min: time.Second, Max: time.Second * 30}, 5, time.Second * 30},
+		{"no overflow", Backoff{Min: time.Second, Max: time.Second * 30}, 1000, time.Second * 30},
+		{"default min", Backoff{}, 0, DefaultBackoff.Min},
+		{"max less than min", Backoff{Min: time.Second * 2, Max: time.Second}, 3, time.Second * 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d := tt.backoff.Duration(tt.attempt)
+				assert.GreaterOrEqual(t, int64(d), int64(tt.max/2))
+				assert.Less(t, int64(d), int64(tt.max))
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"im/logger"
 	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type Channel struct {
@@ -18,6 +20,7 @@ type Channel struct {
 	writewait time.Duration
 	readwait  time.Duration
 	closed    iface.IEvent
+	writedone chan struct{} //wirteloop退出时关闭
 }
 
 func NewChannel(id string, conn iface.IConn) iface.IChannel {
@@ -31,23 +34,40 @@ func NewChannel(id string, conn iface.IConn) iface.IChannel {
 		IConn:     conn,
 		writechan: make(chan []byte, 5),
 		closed:    NewEvent(),
+		writedone: make(chan struct{}),
 		writewait: 3 * time.Second,
 		readwait:  3 * time.Second,
 	}
 
 	go func() {
+		defer close(ch.writedone)
 		err := ch.wirteloop()
 		if err != nil {
 			log.Info(err)
+			// 写入失败时关闭连接，由Readloop退出后清理
+			_ = ch.IConn.Close()
 		}
 	}()
 	return ch
 }
 
+// NewPolledChannel 由事件循环读取消息帧后调用HandleFrame，不占用读写协程，Push直接写入连接
+func NewPolledChannel(id string, conn iface.IConn) *Channel {
+	return &Channel{
+		id:        id,
+		IConn:     conn,
+		closed:    NewEvent(),
+		writewait: 3 * time.Second,
+		readwait:  3 * time.Second,
+	}
+}
+
+// wirteloop 把writechan中已有的消息一起写入后只Flush一次
 func (ch *Channel) wirteloop() error {
 	for {
 		select {
 		case payload := <-ch.writechan:
+			_ = ch.SetWriteDeadline(time.Now().Add(ch.writewait))
 			err := ch.WriteFrame(iface.OpBinary, payload)
 			if err != nil {
 				return err
@@ -65,7 +85,22 @@ func (ch *Channel) wirteloop() error {
 				return err
 			}
 		case <-ch.closed.Done():
-			return nil
+			return ch.drain()
+		}
+	}
+}
+
+// drain 关闭时发送写队列中剩余的消息，并Flush写缓冲
+func (ch *Channel) drain() error {
+	_ = ch.SetWriteDeadline(time.Now().Add(ch.writewait))
+	for {
+		select {
+		case payload := <-ch.writechan:
+			if err := ch.WriteFrame(iface.OpBinary, payload); err != nil {
+				return err
+			}
+		default:
+			return ch.IConn.Flush()
 		}
 	}
 }
@@ -78,17 +113,36 @@ func (ch *Channel) GetMeta() iface.IMeta {
 	return ch.meta
 }
 
+// Push 放入写队列，由wirteloop批量写入；没有写协程的channel直接写入并Flush
 func (ch *Channel) Push(payload []byte) error {
 	if len(payload) == 0 {
 		return nil
 	}
-	return ch.WriteFrame(iface.OpBinary, payload)
+	if ch.closed.HasFired() {
+		return errors.New("channel has closed")
+	}
+	if ch.writechan == nil {
+		_ = ch.SetWriteDeadline(time.Now().Add(ch.writewait))
+		if err := ch.WriteFrame(iface.OpBinary, payload); err != nil {
+			return err
+		}
+		return ch.Flush()
+	}
+	select {
+	case ch.writechan <- payload:
+		return nil
+	case <-ch.closed.Done():
+		return errors.New("channel has closed")
+	}
 }
 
+// Close 等待wirteloop发送完写队列中的消息后返回，之后可以关闭连接
 func (ch *Channel) Close() error {
 	ch.once.Do(func() {
-		close(ch.writechan)
 		ch.closed.Fire()
+		if ch.writedone != nil {
+			<-ch.writedone
+		}
 	})
 	return nil
 }
@@ -104,35 +158,83 @@ func (ch *Channel) SetReadWait(t time.Duration) {
 func (ch *Channel) Readloop(lst iface.IMessageListener) error {
 	ch.Lock()
 	defer ch.Unlock()
-	log := logger.WithFields(logger.Fields{
-		"struct": "ChannelImpl",
-		"func":   "Readloop",
-		"id":     ch.id,
-	})
 	for {
-		_ = ch.SetReadDeadline(time.Now().Add(ch.readwait))
-
-		frame, err := ch.ReadFrame()
-		if err != nil {
+		if err := ch.readOnce(lst); err != nil {
 			return err
 		}
+	}
+}
 
-		if frame.GetOpCode() == iface.OpClose {
-			return errors.New("remote side close the channe")
-		}
+// HandleFrame 处理由事件循环读取的消息帧
+func (ch *Channel) HandleFrame(lst iface.IMessageListener, frame iface.IFrame) error {
+	ch.Lock()
+	defer ch.Unlock()
+	return ch.handleFrame(lst, frame)
+}
+
+// HandleReadError 事件循环读取消息帧失败时调用，返回err
+func (ch *Channel) HandleReadError(err error) error {
+	ch.Lock()
+	defer ch.Unlock()
+	return ch.handleReadError(err)
+}
+
+func (ch *Channel) readOnce(lst iface.IMessageListener) error {
+	_ = ch.SetReadDeadline(time.Now().Add(ch.readwait))
 
-		if frame.GetOpCode() == iface.OpPing {
-			log.Trace("recv a ping; resp with a pong")
-			_ = ch.WriteFrame(iface.OpPong, nil)
-			continue
+	frame, err := ch.ReadFrame()
+	if err != nil {
+		return ch.handleReadError(err)
+	}
+	return ch.handleFrame(lst, frame)
+}
+
+// handleReadError 消息帧过大时通知对端关闭原因
+func (ch *Channel) handleReadError(err error) error {
+	var tooLarge *iface.FrameTooLargeError
+	if errors.As(err, &tooLarge) {
+		ch.logger().Warn(err)
+		_ = ch.SetWriteDeadline(time.Now().Add(ch.writewait))
+		if cw, ok := ch.IConn.(iface.ICloseWriter); ok {
+			_ = cw.WriteClose(iface.CloseMessageTooBig, err.Error())
+		} else {
+			_ = ch.WriteFrame(iface.OpClose, []byte(err.Error()))
 		}
-		payload := frame.GetPayload()
+		_ = ch.Flush()
+	}
+	return err
+}
+
+func (ch *Channel) handleFrame(lst iface.IMessageListener, frame iface.IFrame) error {
+	if frame.GetOpCode() == iface.OpClose {
+		return errors.New("remote side close the channe")
+	}
 
-		if len(payload) == 0 {
-			continue
+	if frame.GetOpCode() == iface.OpPing {
+		ch.logger().Trace("recv a ping; resp with a pong")
+		_ = ch.WriteFrame(iface.OpPong, nil)
+		_ = ch.Flush()
+	}
+	if frame.GetOpCode() == iface.OpPing || frame.GetOpCode() == iface.OpPong {
+		if hl, ok := lst.(iface.IHeartbeatListener); ok {
+			go hl.Heartbeat(ch)
 		}
+		return nil
+	}
+	payload := frame.GetPayload()
 
-		go lst.Receive(ch, payload)
+	if len(payload) == 0 {
+		return nil
 	}
+
+	go lst.Receive(ch, payload)
 	return nil
 }
+
+func (ch *Channel) logger() *logrus.Entry {
+	return logger.WithFields(logger.Fields{
+		"struct": "ChannelImpl",
+		"func":   "Readloop",
+		"id":     ch.id,
+	})
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bytes"
+	"im/iface"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bufferedConn WriteFrame只写入缓冲，Flush之后才算发送
+type bufferedConn struct {
+	net.Conn
+	sync.Mutex
+	buffered [][]byte
+	sent     [][]byte
+	block    chan struct{}
+}
+
+func (c *bufferedConn) ReadFrame() (iface.IFrame, error) { return nil, nil }
+
+func (c *bufferedConn) WriteFrame(_ iface.OpCode, p []byte) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.buffered = append(c.buffered, p)
+	return nil
+}
+
+func (c *bufferedConn) Flush() error {
+	c.Lock()
+	defer c.Unlock()
+	c.sent = append(c.sent, c.buffered...)
+	c.buffered = nil
+	return nil
+}
+
+func (c *bufferedConn) SetWriteDeadline(time.Time) error { return nil }
+
+// 关闭时写队列中的消息全部发送
+func TestChannelCloseDrain(t *testing.T) {
+	conn := &bufferedConn{block: make(chan struct{})}
+	ch := NewChannel("c1", conn)
+
+	// wirteloop阻塞在第一个消息上，其余的留在写队列中
+	var want [][]byte
+	for i := 0; i < 5; i++ {
+		p := []byte{byte('a' + i)}
+		want = append(want, p)
+		assert.Nil(t, ch.Push(p))
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		close(conn.block)
+	}()
+	assert.Nil(t, ch.Close())
+
+	conn.Lock()
+	defer conn.Unlock()
+	assert.Equal(t, want, conn.sent)
+	assert.Empty(t, conn.buffered)
+	assert.NotNil(t, ch.Push([]byte("f")))
+}
+
+func TestChannelCloseFlush(t *testing.T) {
+	conn := &bufferedConn{}
+	ch := NewChannel("c1", conn)
+	assert.Nil(t, ch.Push([]byte("a")))
+	assert.Nil(t, ch.Close())
+
+	conn.Lock()
+	defer conn.Unlock()
+	assert.True(t, bytes.Equal([]byte("a"), conn.sent[0]))
+}
+
+type opFrame struct {
+	op      iface.OpCode
+	payload []byte
+}
+
+func (f *opFrame) SetOpCode(op iface.OpCode) { f.op = op }
+func (f *opFrame) GetOpCode() iface.OpCode   { return f.op }
+func (f *opFrame) SetPayload(p []byte)       { f.payload = p }
+func (f *opFrame) GetPayload() []byte        { return f.payload }
+
+// heartbeatListener 记录收到的业务消息与心跳
+type heartbeatListener struct {
+	received   chan []byte
+	heartbeats chan string
+}
+
+func (l *heartbeatListener) Receive(ag iface.IAgent, p []byte) { l.received <- p }
+func (l *heartbeatListener) Heartbeat(ag iface.IAgent)         { l.heartbeats <- ag.ID() }
+
+// 传输层的ping与pong回调Heartbeat，不作为业务消息
+func TestChannelHeartbeat(t *testing.T) {
+	tests := []struct {
+		name      string
+		op        iface.OpCode
+		payload   []byte
+		heartbeat bool
+	}{
+		{"ping", iface.OpPing, nil, true},
+		{"pong", iface.OpPong, nil, true},
+		{"message", iface.OpBinary, []byte("a"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lst := &heartbeatListener{received: make(chan []byte, 1), heartbeats: make(chan string, 1)}
+			ch := NewChannel("c1", &bufferedConn{})
+			defer ch.Close()
+			assert.Nil(t, ch.(*Channel).HandleFrame(lst, &opFrame{op: tt.op, payload: tt.payload}))
+
+			select {
+			case id := <-lst.heartbeats:
+				assert.True(t, tt.heartbeat)
+				assert.Equal(t, "c1", id)
+			case p := <-lst.received:
+				assert.False(t, tt.heartbeat)
+				assert.Equal(t, tt.payload, p)
+			case <-time.After(time.Second):
+				t.Fatal("frame is not handled")
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// PingTracker 记录已发送但还没有收到pong的ping，按发送顺序与pong对应，计算往返时间
+type PingTracker struct {
+	sync.Mutex
+	sent []time.Time
+	rtt  time.Duration
+	now  func() time.Time //为空时使用time.Now，测试时替换
+}
+
+func (t *PingTracker) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Ping 发送ping之后调用，返回未收到pong的ping数
+func (t *PingTracker) Ping() int {
+	t.Lock()
+	defer t.Unlock()
+	t.sent = append(t.sent, t.clock())
+	return len(t.sent)
+}
+
+// Pong 收到pong时调用，对应最早发出的ping
+func (t *PingTracker) Pong() {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.sent) == 0 {
+		return
+	}
+	t.rtt = t.clock().Sub(t.sent[0])
+	t.sent = t.sent[1:]
+}
+
+// Outstanding 未收到pong的ping数
+func (t *PingTracker) Outstanding() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.sent)
+}
+
+// RTT 最近一次的往返时间，还没有收到pong时为0
+func (t *PingTracker) RTT() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	return t.rtt
+}
+
+// Reset 重新建立连接之后清空未收到pong的ping
+func (t *PingTracker) Reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.sent = nil
+}
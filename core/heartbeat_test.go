@@ -0,0 +1,60 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestPingTrackerRTT(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tracker := &PingTracker{now: clock.Now}
+	assert.Equal(t, time.Duration(0), tracker.RTT())
+
+	assert.Equal(t, 1, tracker.Ping())
+	clock.Advance(time.Millisecond * 30)
+	tracker.Pong()
+	assert.Equal(t, time.Millisecond*30, tracker.RTT())
+	assert.Equal(t, 0, tracker.Outstanding())
+
+	// pong按发送顺序对应ping
+	tracker.Ping()
+	clock.Advance(time.Millisecond * 10)
+	assert.Equal(t, 2, tracker.Ping())
+	clock.Advance(time.Millisecond * 5)
+	tracker.Pong()
+	assert.Equal(t, time.Millisecond*15, tracker.RTT())
+	clock.Advance(time.Millisecond * 20)
+	tracker.Pong()
+	assert.Equal(t, time.Millisecond*25, tracker.RTT())
+
+	// 没有未收到pong的ping时忽略
+	tracker.Pong()
+	assert.Equal(t, time.Millisecond*25, tracker.RTT())
+}
+
+func TestPingTrackerReset(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tracker := &PingTracker{now: clock.Now}
+	tracker.Ping()
+	tracker.Ping()
+	assert.Equal(t, 2, tracker.Outstanding())
+	tracker.Reset()
+	assert.Equal(t, 0, tracker.Outstanding())
+
+	// 重连之后旧连接的ping不参与计算
+	clock.Advance(time.Second)
+	tracker.Ping()
+	clock.Advance(time.Millisecond * 40)
+	tracker.Pong()
+	assert.Equal(t, time.Millisecond*40, tracker.RTT())
+}
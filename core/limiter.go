@@ -0,0 +1,166 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrTooManyConns      = errors.New("too many connections")
+	ErrTooManyConnsPerIP = errors.New("too many connections from the same ip")
+	ErrTooManyHandshakes = errors.New("too many handshakes in progress")
+)
+
+// ConnLimits 连接数限制，为0时不限制
+type ConnLimits struct {
+	MaxConns      int //总连接数
+	MaxConnsPerIP int //单个ip的连接数
+	MaxHandshakes int //正在握手(等待登录包)的连接数
+}
+
+// ConnStats 连接计数
+type ConnStats struct {
+	Conns      int
+	Handshakes int
+	Rejected   int64
+}
+
+// ConnLimiter 在读取登录包之前判断是否接受一个连接
+//
+// 使用方式:
+//
+//	if err := limiter.Acquire(ip); err != nil { 拒绝 }
+//	defer limiter.Release(ip)
+//	Accept(...)
+//	limiter.HandshakeDone()
+//
+// 需要先读取数据才能知道客户端ip时(如PROXY protocol)，分两步占用:
+//
+//	if err := limiter.AcquireHandshake(); err != nil { 拒绝 }
+//	ip := ...
+//	if err := limiter.AcquireConn(ip); err != nil { limiter.HandshakeDone(); 拒绝 }
+type ConnLimiter struct {
+	sync.Mutex
+	module     string
+	limits     ConnLimits
+	conns      int
+	handshakes int
+	rejected   int64
+	perIP      map[string]int
+}
+
+// NewConnLimiter module为指标中的module标签
+func NewConnLimiter(module string, limits ConnLimits) *ConnLimiter {
+	return &ConnLimiter{
+		module: module,
+		limits: limits,
+		perIP:  make(map[string]int),
+	}
+}
+
+// Acquire 占用一个连接与一个握手名额
+func (l *ConnLimiter) Acquire(ip string) error {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.checkConn(ip); err != nil {
+		return err
+	}
+	if err := l.checkHandshake(); err != nil {
+		return err
+	}
+	l.addConn(ip)
+	l.addHandshake()
+	return nil
+}
+
+// AcquireHandshake 只占用一个握手名额，之后需要调用AcquireConn或HandshakeDone
+func (l *ConnLimiter) AcquireHandshake() error {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.checkHandshake(); err != nil {
+		return err
+	}
+	l.addHandshake()
+	return nil
+}
+
+// AcquireConn 在AcquireHandshake之后占用一个连接名额
+func (l *ConnLimiter) AcquireConn(ip string) error {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.checkConn(ip); err != nil {
+		return err
+	}
+	l.addConn(ip)
+	return nil
+}
+
+func (l *ConnLimiter) checkConn(ip string) error {
+	switch {
+	case l.limits.MaxConns > 0 && l.conns >= l.limits.MaxConns:
+		connRejected.WithLabelValues(l.module, "total").Inc()
+		l.rejected++
+		return ErrTooManyConns
+	case l.limits.MaxConnsPerIP > 0 && l.perIP[ip] >= l.limits.MaxConnsPerIP:
+		connRejected.WithLabelValues(l.module, "ip").Inc()
+		l.rejected++
+		return ErrTooManyConnsPerIP
+	}
+	return nil
+}
+
+func (l *ConnLimiter) checkHandshake() error {
+	if l.limits.MaxHandshakes > 0 && l.handshakes >= l.limits.MaxHandshakes {
+		connRejected.WithLabelValues(l.module, "handshake").Inc()
+		l.rejected++
+		return ErrTooManyHandshakes
+	}
+	return nil
+}
+
+func (l *ConnLimiter) addConn(ip string) {
+	l.conns++
+	l.perIP[ip]++
+	connActive.WithLabelValues(l.module).Inc()
+}
+
+func (l *ConnLimiter) addHandshake() {
+	l.handshakes++
+	connHandshaking.WithLabelValues(l.module).Inc()
+}
+
+// HandshakeDone 握手结束，无论成功与否都需要调用
+func (l *ConnLimiter) HandshakeDone() {
+	l.Lock()
+	defer l.Unlock()
+	if l.handshakes > 0 {
+		l.handshakes--
+		connHandshaking.WithLabelValues(l.module).Dec()
+	}
+}
+
+// Release 连接关闭时释放名额
+func (l *ConnLimiter) Release(ip string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.conns > 0 {
+		l.conns--
+		connActive.WithLabelValues(l.module).Dec()
+	}
+	if n := l.perIP[ip]; n > 1 {
+		l.perIP[ip] = n - 1
+	} else {
+		delete(l.perIP, ip)
+	}
+}
+
+// Stats 当前的连接计数
+func (l *ConnLimiter) Stats() ConnStats {
+	l.Lock()
+	defer l.Unlock()
+	return ConnStats{
+		Conns:      l.conns,
+		Handshakes: l.handshakes,
+		Rejected:   l.rejected,
+	}
+}
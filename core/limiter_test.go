@@ -0,0 +1,69 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ConnLimits
+		ips    []string
+		err    error
+	}{
+		{"no limit", ConnLimits{}, []string{"a", "a", "a"}, nil},
+		{"total", ConnLimits{MaxConns: 2}, []string{"a", "b", "c"}, ErrTooManyConns},
+		{"per ip", ConnLimits{MaxConnsPerIP: 2}, []string{"a", "a", "a"}, ErrTooManyConnsPerIP},
+		{"per ip other ip", ConnLimits{MaxConnsPerIP: 2}, []string{"a", "a", "b"}, nil},
+		{"handshake", ConnLimits{MaxHandshakes: 2}, []string{"a", "b", "c"}, ErrTooManyHandshakes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewConnLimiter("test", tt.limits)
+			last := len(tt.ips) - 1
+			for _, ip := range tt.ips[:last] {
+				assert.Nil(t, l.Acquire(ip))
+			}
+			assert.Equal(t, tt.err, l.Acquire(tt.ips[last]))
+
+			stats := l.Stats()
+			if tt.err != nil {
+				assert.Equal(t, ConnStats{Conns: last, Handshakes: last, Rejected: 1}, stats)
+			} else {
+				assert.Equal(t, ConnStats{Conns: last + 1, Handshakes: last + 1}, stats)
+			}
+		})
+	}
+}
+
+func TestConnLimiterRelease(t *testing.T) {
+	l := NewConnLimiter("test", ConnLimits{MaxConnsPerIP: 1, MaxHandshakes: 1})
+	assert.Nil(t, l.Acquire("a"))
+	assert.Equal(t, ErrTooManyHandshakes, l.Acquire("b"))
+
+	// 握手结束后释放握手名额，连接名额保留
+	l.HandshakeDone()
+	assert.Equal(t, ErrTooManyConnsPerIP, l.Acquire("a"))
+	assert.Nil(t, l.Acquire("b"))
+	l.HandshakeDone()
+
+	l.Release("a")
+	assert.Nil(t, l.Acquire("a"))
+	assert.Equal(t, ConnStats{Conns: 2, Handshakes: 1, Rejected: 2}, l.Stats())
+}
+
+// 两步占用时，未知ip的连接也占用握手名额
+func TestConnLimiterAcquireHandshake(t *testing.T) {
+	l := NewConnLimiter("test", ConnLimits{MaxConnsPerIP: 1, MaxHandshakes: 2})
+	assert.Nil(t, l.AcquireHandshake())
+	assert.Nil(t, l.AcquireHandshake())
+	assert.Equal(t, ErrTooManyHandshakes, l.AcquireHandshake())
+	assert.Equal(t, ConnStats{Conns: 0, Handshakes: 2, Rejected: 1}, l.Stats())
+
+	assert.Nil(t, l.AcquireConn("a"))
+	assert.Equal(t, ErrTooManyConnsPerIP, l.AcquireConn("a"))
+	l.HandshakeDone()
+	assert.Equal(t, ConnStats{Conns: 1, Handshakes: 1, Rejected: 2}, l.Stats())
+}
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var connActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "kim",
+	Name:      "conn_active",
+	Help:      "当前的连接数",
+}, []string{"module"})
+
+var connHandshaking = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "kim",
+	Name:      "conn_handshaking",
+	Help:      "正在握手的连接数",
+}, []string{"module"})
+
+var connRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kim",
+	Name:      "conn_rejected_total",
+	Help:      "超过限制被拒绝的连接数",
+}, []string{"module", "reason"})
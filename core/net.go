@@ -17,3 +17,21 @@ func GetLocalIP() string {
 	}
 	return ""
 }
+
+// RemoteIP 返回地址中的ip部分，无法解析时原样返回
+func RemoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TrustedProxies 受信任的代理(负载均衡)地址段，只有来自这些地址的代理信息才会被采用
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies 解析CIDR列表，单个ip等同于/32或/128
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", cidr)
+		}
+		proxies = append(proxies, ipnet)
+	}
+	return proxies, nil
+}
+
+// Contains ip是否在受信任的地址段中
+func (t TrustedProxies) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipnet := range t {
+		if ipnet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
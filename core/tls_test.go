@@ -0,0 +1,56 @@
+package core
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testdata中的证书由同一个ca签发，stranger是自签名的客户端证书
+func TestNewServerTLSConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		cert     string
+		key      string
+		clientCA string
+		auth     tls.ClientAuthType
+		wantErr  bool
+	}{
+		{"server", "testdata/server.pem", "testdata/server-key.pem", "", tls.NoClientCert, false},
+		{"client ca", "testdata/server.pem", "testdata/server-key.pem", "testdata/ca.pem", tls.RequireAndVerifyClientCert, false},
+		{"key mismatch", "testdata/server.pem", "testdata/client-key.pem", "", tls.NoClientCert, true},
+		{"missing cert", "testdata/none.pem", "testdata/server-key.pem", "", tls.NoClientCert, true},
+		{"ca without cert", "testdata/server.pem", "testdata/server-key.pem", "testdata/server-key.pem", tls.NoClientCert, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := NewServerTLSConfig(c.cert, c.key, c.clientCA)
+			if c.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Len(t, cfg.Certificates, 1)
+			assert.Equal(t, c.auth, cfg.ClientAuth)
+			assert.Equal(t, c.clientCA != "", cfg.ClientCAs != nil)
+		})
+	}
+}
+
+func TestNewClientTLSConfig(t *testing.T) {
+	cfg, err := NewClientTLSConfig("", "", "")
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.RootCAs)
+	assert.Empty(t, cfg.Certificates)
+
+	cfg, err = NewClientTLSConfig("testdata/ca.pem", "testdata/client.pem", "testdata/client-key.pem")
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Len(t, cfg.Certificates, 1)
+
+	_, err = NewClientTLSConfig("testdata/none.pem", "", "")
+	assert.NotNil(t, err)
+	_, err = NewClientTLSConfig("testdata/ca.pem", "testdata/client.pem", "testdata/server-key.pem")
+	assert.NotNil(t, err)
+}
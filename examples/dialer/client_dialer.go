@@ -2,10 +2,10 @@ package dialer
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"im/iface"
 	"im/logger"
+	"im/websocket"
 	"net"
 	"time"
 
@@ -23,7 +23,7 @@ type ClientDialer struct {
 func (d *ClientDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
 	logger.Info("DialAndHandshake called")
 	// 1. 拨号
-	conn, _, _, err := ws.Dial(context.TODO(), ctx.Address)
+	conn, err := websocket.Dial(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -1,7 +1,7 @@
 package mock
 
 import (
-	"context"
+	"crypto/tls"
 	"fmt"
 	"im/iface"
 	"im/logger"
@@ -9,7 +9,6 @@ import (
 	"net"
 	"time"
 
-	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 )
 
@@ -68,7 +67,7 @@ func (c *ClientDemo) Start(userID string, protocol, addr string) {
 type WebSocketDialer struct{}
 
 func (d *WebSocketDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
-	conn, _, _, err := ws.Dial(context.TODO(), ctx.Address)
+	conn, err := websocket.Dial(ctx)
 	if err != nil {
 		return conn, err
 	}
@@ -87,6 +86,9 @@ type TcpDialer struct{}
 func (d *TcpDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
 	logger.Info("start dial: ", ctx.Address)
 
+	if ctx.TLSConfig != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: ctx.Timeout}, "tcp", ctx.Address, ctx.TLSConfig)
+	}
 	conn, err := net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
 	if err != nil {
 		return conn, err
@@ -3,6 +3,7 @@ module im
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/bwmarrin/snowflake v0.3.0
 	github.com/go-redis/redis/v7 v7.4.0
 	github.com/go-resty/resty/v2 v2.6.0
@@ -25,5 +26,6 @@ require (
 	github.com/stretchr/testify v1.7.0
 	google.golang.org/protobuf v1.26.0-rc.1
 	gorm.io/driver/mysql v1.1.1
+	gopkg.in/yaml.v2 v2.3.0
 	gorm.io/gorm v1.21.15
 )
@@ -0,0 +1,16 @@
+package iface
+
+import "fmt"
+
+// DefaultMaxPayload 默认的消息帧最大长度
+const DefaultMaxPayload = 4 * 1024 * 1024
+
+// FrameTooLargeError 对方发送的消息帧超过了最大长度
+type FrameTooLargeError struct {
+	Size uint64
+	Max  int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame too large: %d > %d", e.Size, e.Max)
+}
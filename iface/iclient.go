@@ -1,14 +1,41 @@
 package iface
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 )
 
 const (
 	DefaultWriteWait time.Duration = 3 * time.Second
+	// DefaultMaxMissedPongs 连续这么多次心跳没有收到pong时认为对端已断开
+	DefaultMaxMissedPongs = 3
 )
 
+// ConnState 客户端的连接状态
+type ConnState int32
+
+const (
+	ConnDisconnected ConnState = iota
+	ConnConnected
+	// ConnReconnecting 连接断开，正在重新拨号与握手
+	ConnReconnecting
+	// ConnClosed 调用了Close，不会再重连
+	ConnClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnected:
+		return "connected"
+	case ConnReconnecting:
+		return "reconnecting"
+	case ConnClosed:
+		return "closed"
+	}
+	return "disconnected"
+}
+
 //客户端
 type IClient interface {
 	IService
@@ -21,6 +48,11 @@ type IClient interface {
 	Close()
 }
 
+// IRoundTrip 能够通过心跳测量往返时间的客户端
+type IRoundTrip interface {
+	RTT() time.Duration
+}
+
 type IDialer interface {
 	DialAndHandshake(DialerContext) (net.Conn, error)
 }
@@ -30,4 +62,10 @@ type DialerContext struct {
 	Name    string
 	Address string
 	Timeout time.Duration
+	//不为空时使用tls拨号
+	TLSConfig *tls.Config
+	//websocket连接是否协商permessage-deflate
+	Compression bool
+	//服务注册的元数据，连接服务时可以为空
+	Meta map[string]string
 }
@@ -2,11 +2,28 @@ package iface
 
 import "net"
 
+// DefaultWriteBuffer 服务端连接默认的写缓冲大小
+const DefaultWriteBuffer = 4096
+
 //连接
 type IConn interface {
 	net.Conn
 	//读取消息帧
 	ReadFrame() (IFrame, error)
+	//写入消息帧，带写缓冲的连接需要调用Flush才会发送
 	WriteFrame(OpCode, []byte) error
 	Flush() error
 }
+
+// CloseMessageTooBig 消息帧超过最大长度时关闭连接的状态码，与websocket的1009相同
+const CloseMessageTooBig uint16 = 1009
+
+// ICloseWriter 关闭帧需要带状态码的连接(websocket)，由连接自己构造关闭帧
+type ICloseWriter interface {
+	WriteClose(code uint16, reason string) error
+}
+
+// IAuthConn 握手阶段已经完成鉴权的连接，登录时需要与登录包中的账号一致
+type IAuthConn interface {
+	Identity() string
+}
@@ -5,6 +5,12 @@ type IMessageListener interface {
 	Receive(IAgent, []byte)
 }
 
+// IHeartbeatListener 可选，消息监听器实现时在收到传输层的ping或pong时回调，
+// 用于没有业务消息的空闲连接刷新会话
+type IHeartbeatListener interface {
+	Heartbeat(IAgent)
+}
+
 // 发送方
 type IAgent interface {
 	//返回连接的channelid
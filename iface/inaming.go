@@ -1,15 +1,20 @@
 package iface
 
+import (
+	"errors"
+	"time"
+)
+
 type Naming interface {
 
 	//注册
 	Register(service ServiceRegistration) error
 	//注销
 	Deregister(string) error
-	//服务发现
-	Find(serviceName string, name ...string) ([]ServiceRegistration, error)
-	//订阅
-	Subscribe(serviceName string, callback func(servicies []ServiceRegistration)) error
+	//服务发现，只返回同一namespace下带有所有tags的服务
+	Find(serviceName string, tags ...string) ([]ServiceRegistration, error)
+	//订阅，过滤规则与Find相同
+	Subscribe(serviceName string, callback func(servicies []ServiceRegistration), tags ...string) error
 	//退订
 	UnSubscribe(serviceName string) error
 }
@@ -29,3 +34,13 @@ type ServiceRegistration interface {
 }
 
 type IMeta map[string]string
+
+// ErrNotRegistered 服务没有注册成功或者注册中心丢失了检查，需要重新注册
+var ErrNotRegistered = errors.New("err:service not registered")
+
+// HealthReporter 使用TTL健康检查的注册中心，由容器在TTL内定时上报服务存活，
+// UpdateTTL返回ErrNotRegistered时由容器重新注册
+type HealthReporter interface {
+	TTL() time.Duration
+	UpdateTTL(serviceID string) error
+}
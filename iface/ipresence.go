@@ -0,0 +1,15 @@
+package iface
+
+import "time"
+
+// IPresenceStorage 保存账号的最近在线时间与在线状态的订阅关系
+type IPresenceStorage interface {
+	// SetLastSeen 记录账号最近一次在线的时间
+	SetLastSeen(account string, t time.Time) error
+	// GetLastSeen 返回账号最近一次在线的时间(毫秒)，没有记录的账号不在结果中
+	GetLastSeen(accounts ...string) (map[string]int64, error)
+	Subscribe(subscriber string, accounts ...string) error
+	Unsubscribe(subscriber string, accounts ...string) error
+	// Subscribers 返回订阅了account的所有账号
+	Subscribers(account string) ([]string, error)
+}
@@ -40,6 +40,11 @@ type IServer interface {
 	Shutdown(context.Context) error
 }
 
+// IMultiRegistration 在多个地址上提供服务的server，容器在注册中心注册其中的每一个
+type IMultiRegistration interface {
+	Registrations() []ServiceRegistration
+}
+
 // 服务接受者
 type IAcceptor interface {
 	//返回一个id
@@ -2,16 +2,26 @@ package iface
 
 import (
 	"errors"
+	"time"
 
 	"github.com/klintcheng/kim/wire/pkt"
 )
 
 var ErrSessionNil = errors.New("err:session nil")
 
+// CommandSessionKeepAlive 连接活跃时网关通知逻辑服务刷新会话的过期时间
+const CommandSessionKeepAlive = "login.keepalive"
+
 type ISessionStorage interface {
 	Add(session *pkt.Session) error
 	Delete(account string, channleID string) error
 	Get(string) (*pkt.Session, error)
 	GetLocations(...string) ([]*Location, error)
 	GetLocation(string, string) (*Location, error)
+	// Refresh 刷新会话及其位置的过期时间
+	Refresh(channelId string) error
+	// PurgeGate 删除网关上所有在before之前登录的会话，返回这些会话的账号，用于网关崩溃或重启后的清理
+	PurgeGate(gateId string, before time.Time) ([]string, error)
+	// RemoveExpired 删除在now之前过期的会话，返回这些会话的账号，用于通知在线状态的订阅者
+	RemoveExpired(now time.Time) ([]string, error)
 }
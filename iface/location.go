@@ -9,6 +9,7 @@ import (
 type Location struct {
 	ChannelID string //网关中的channelID
 	GateId    string //网关ID
+	Account   string //所属账号，不参与编码，由GetLocations填充
 }
 
 func (loc *Location) Bytes() []byte {
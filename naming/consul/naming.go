@@ -6,6 +6,7 @@ import (
 	"im/iface"
 	"im/logger"
 	"im/naming"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,6 +16,20 @@ import (
 const (
 	KeyProtocol  = "protocol"
 	KeyHealthURL = "health_url"
+	// KeyNamespace consul社区版没有namespace，保存在meta中由客户端过滤
+	KeyNamespace = "namespace"
+	// KeyHealthCheck 没有KeyHealthURL时的检查方式，为空时使用ttl
+	KeyHealthCheck = "health_check"
+)
+
+const (
+	HealthCheckTTL = "ttl"
+	HealthCheckTCP = "tcp"
+)
+
+const (
+	DefaultTTL                     = time.Second * 10
+	DefaultDeregisterCriticalAfter = time.Second * 20
 )
 
 const (
@@ -26,27 +41,69 @@ const (
 
 type Watch struct {
 	Service   string
+	Tags      []string
 	Callback  func([]iface.ServiceRegistration)
 	WaitIndex uint64
 	Quit      chan struct{}
 }
 
+type NamingOptions struct {
+	namespace               string
+	ttl                     time.Duration
+	deregisterCriticalAfter time.Duration
+}
+
+type Option func(opts *NamingOptions)
+
+// WithNamespace 只发现namespace下的服务，默认为空。
+// 同一个consul中部署多套集群时，不同namespace的服务互相不可见
+func WithNamespace(namespace string) Option {
+	return func(opts *NamingOptions) {
+		opts.namespace = namespace
+	}
+}
+
+// WithTTL 设置ttl检查的超时时间，服务需要在ttl内调用UpdateTTL
+func WithTTL(ttl time.Duration) Option {
+	return func(opts *NamingOptions) {
+		opts.ttl = ttl
+	}
+}
+
+// WithDeregisterCriticalAfter 健康检查失败超过d之后由consul注销服务
+func WithDeregisterCriticalAfter(d time.Duration) Option {
+	return func(opts *NamingOptions) {
+		opts.deregisterCriticalAfter = d
+	}
+}
+
 type Naming struct {
 	sync.RWMutex
 	cli     *api.Client
 	watches map[string]*Watch
+	options NamingOptions
+	checks  map[string]string //id -> ttl checkID，没有ttl检查时为空
 }
 
-func NewNaming(consulUrl string) (iface.Naming, error) {
+func NewNaming(consulUrl string, opts ...Option) (iface.Naming, error) {
 	conf := api.DefaultConfig()
 	conf.Address = consulUrl
 	cli, err := api.NewClient(conf)
 	if err != nil {
 		return nil, err
 	}
+	options := NamingOptions{
+		ttl:                     DefaultTTL,
+		deregisterCriticalAfter: DefaultDeregisterCriticalAfter,
+	}
+	for _, option := range opts {
+		option(&options)
+	}
 	nami := &Naming{
 		cli:     cli,
 		watches: make(map[string]*Watch),
+		options: options,
+		checks:  make(map[string]string),
 	}
 	return nami, nil
 }
@@ -65,25 +122,102 @@ func (n *Naming) Register(s iface.ServiceRegistration) error {
 		reg.Meta = make(map[string]string)
 	}
 	reg.Meta[KeyProtocol] = s.GetProtocol()
+	if s.GetNamespace() != "" {
+		reg.Meta[KeyNamespace] = s.GetNamespace()
+	}
 	// consul健康检查
 	healthURL := s.GetMeta()[KeyHealthURL]
+	deregisterAfter := n.options.deregisterCriticalAfter.String()
+	var ttlCheckID string
 	if healthURL != "" {
 		check := new(api.AgentServiceCheck)
 		check.CheckID = fmt.Sprintf("%s_normal", s.ServiceID())
 		check.HTTP = healthURL
 		check.Timeout = "1s" // http timeout
 		check.Interval = "10s"
-		check.DeregisterCriticalServiceAfter = "20s"
+		check.DeregisterCriticalServiceAfter = deregisterAfter
 		reg.Check = check
+	} else {
+		switch healthCheck(s) {
+		case HealthCheckTTL:
+			// 初始状态为passing，之后需要在ttl内调用UpdateTTL
+			ttlCheckID = fmt.Sprintf("%s_ttl", s.ServiceID())
+			reg.Check = &api.AgentServiceCheck{
+				CheckID:                        ttlCheckID,
+				TTL:                            n.options.ttl.String(),
+				Status:                         api.HealthPassing,
+				DeregisterCriticalServiceAfter: deregisterAfter,
+			}
+		case HealthCheckTCP:
+			reg.Check = &api.AgentServiceCheck{
+				CheckID:                        fmt.Sprintf("%s_tcp", s.ServiceID()),
+				TCP:                            fmt.Sprintf("%s:%d", s.PublicAddress(), s.PublicPort()),
+				Timeout:                        "1s",
+				Interval:                       "10s",
+				DeregisterCriticalServiceAfter: deregisterAfter,
+			}
+		}
 	}
 	err := n.cli.Agent().ServiceRegister(reg)
-	return err
+	if err != nil {
+		return err
+	}
+	n.Lock()
+	n.checks[s.ServiceID()] = ttlCheckID
+	n.Unlock()
+	return nil
+}
+
+// healthCheck 没有配置检查方式时使用ttl检查，unix等consul无法探测的协议同样由心跳上报存活
+func healthCheck(s iface.ServiceRegistration) string {
+	if check := s.GetMeta()[KeyHealthCheck]; check != "" {
+		return check
+	}
+	return HealthCheckTTL
 }
 
 func (n *Naming) Deregister(serviceID string) error {
+	n.Lock()
+	delete(n.checks, serviceID)
+	n.Unlock()
 	return n.cli.Agent().ServiceDeregister(serviceID)
 }
 
+// TTL 实现iface.HealthReporter
+func (n *Naming) TTL() time.Duration {
+	return n.options.ttl
+}
+
+// UpdateTTL 上报服务存活，没有使用ttl检查的服务直接返回。
+// 服务没有注册成功，或者consul agent重启后丢失了检查时返回iface.ErrNotRegistered
+func (n *Naming) UpdateTTL(serviceID string) error {
+	n.RLock()
+	checkID, ok := n.checks[serviceID]
+	n.RUnlock()
+	if !ok {
+		return iface.ErrNotRegistered
+	}
+	if checkID == "" {
+		return nil
+	}
+	err := n.cli.Agent().UpdateTTL(checkID, "", api.HealthPassing)
+	if checkMissing(err) {
+		return iface.ErrNotRegistered
+	}
+	return err
+}
+
+// checkMissing agent上没有这个检查，新版本返回404，旧版本返回500
+func checkMissing(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Unexpected response code: 404") ||
+		strings.Contains(msg, "Unknown check") ||
+		strings.Contains(msg, "does not have associated TTL")
+}
+
 func (n *Naming) Find(name string, tags ...string) ([]iface.ServiceRegistration, error) {
 	services, _, err := n.load(name, 0, tags...)
 	return services, err
@@ -100,27 +234,32 @@ func (n *Naming) load(name string, waitIndex uint64, tags ...string) ([]iface.Se
 		return nil, meta, err
 	}
 
-	services := make([]iface.ServiceRegistration, len(catalogServices))
-	for i, s := range catalogServices {
+	services := make([]iface.ServiceRegistration, 0, len(catalogServices))
+	for _, s := range catalogServices {
 		if s.Checks.AggregatedStatus() != api.HealthPassing {
 			logger.Debugf("load service: id:%s name:%s %s:%d Status:%s", s.ServiceID, s.ServiceName, s.ServiceAddress, s.ServicePort, s.Checks.AggregatedStatus())
 			continue
 		}
-		services[i] = &naming.DefaultService{
-			Id:       s.ServiceID,
-			Name:     s.ServiceName,
-			Address:  s.ServiceAddress,
-			Port:     s.ServicePort,
-			Protocol: s.ServiceMeta[KeyProtocol],
-			Tags:     s.ServiceTags,
-			Meta:     s.ServiceMeta,
+		service := &naming.DefaultService{
+			Id:        s.ServiceID,
+			Name:      s.ServiceName,
+			Address:   s.ServiceAddress,
+			Port:      s.ServicePort,
+			Protocol:  s.ServiceMeta[KeyProtocol],
+			Namespace: s.ServiceMeta[KeyNamespace],
+			Tags:      s.ServiceTags,
+			Meta:      s.ServiceMeta,
+		}
+		if !naming.Match(service, n.options.namespace, nil) {
+			continue
 		}
+		services = append(services, service)
 	}
 	logger.Debugf("load service: %v, meta:%#v", services, meta)
 	return services, meta, nil
 }
 
-func (n *Naming) Subscribe(serviceName string, callback func([]iface.ServiceRegistration)) error {
+func (n *Naming) Subscribe(serviceName string, callback func([]iface.ServiceRegistration), tags ...string) error {
 	n.Lock()
 	defer n.Unlock()
 
@@ -130,11 +269,12 @@ func (n *Naming) Subscribe(serviceName string, callback func([]iface.ServiceRegi
 
 	w := &Watch{
 		Service:  serviceName,
+		Tags:     tags,
 		Callback: callback,
 		Quit:     make(chan struct{}),
 	}
 	n.watches[serviceName] = w
-	n.watch(w)
+	go n.watch(w)
 	return nil
 }
 
@@ -152,7 +292,7 @@ func (n *Naming) UnSubscribe(serviceName string) error {
 func (n *Naming) watch(wh *Watch) {
 	stopped := false
 	var doWatch = func(service string, callback func([]iface.ServiceRegistration)) {
-		services, meta, err := n.load(service, wh.WaitIndex) // <-- blocking untill services has changed
+		services, meta, err := n.load(service, wh.WaitIndex, wh.Tags...) // <-- blocking untill services has changed
 		if err != nil {
 			logger.Warn(err)
 			return
@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"im/iface"
+	"im/naming"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		meta     map[string]string
+		want     string
+	}{
+		{"tcp", "tcp", nil, HealthCheckTTL},
+		{"ws", "ws", nil, HealthCheckTTL},
+		{"wss", "wss", nil, HealthCheckTTL},
+		{"unix", naming.ProtocolUnix, nil, HealthCheckTTL},
+		{"pipe", naming.ProtocolPipe, nil, HealthCheckTTL},
+		{"configured", "tcp", map[string]string{KeyHealthCheck: HealthCheckTCP}, HealthCheckTCP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &naming.DefaultService{Id: "s1", Name: "chat", Protocol: tt.protocol, Meta: tt.meta}
+			assert.Equal(t, tt.want, healthCheck(s))
+		})
+	}
+}
+
+// agent只接受注册，丢失了检查时返回404
+func newAgent(t *testing.T, checkStatus int) *Naming {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/") {
+			w.WriteHeader(checkStatus)
+			_, _ = w.Write([]byte("Unknown check ID"))
+		}
+	}))
+	t.Cleanup(agent.Close)
+	ns, err := NewNaming(strings.TrimPrefix(agent.URL, "http://"))
+	assert.Nil(t, err)
+	return ns.(*Naming)
+}
+
+func TestUpdateTTL(t *testing.T) {
+	ttl := &naming.DefaultService{Id: "chat01", Name: "chat", Protocol: "tcp"}
+	tcp := &naming.DefaultService{Id: "chat02", Name: "chat", Protocol: "tcp", Meta: map[string]string{KeyHealthCheck: HealthCheckTCP}}
+	tests := []struct {
+		name        string
+		service     iface.ServiceRegistration
+		register    bool
+		checkStatus int
+		want        error
+	}{
+		{"passing", ttl, true, http.StatusOK, nil},
+		{"not registered", ttl, false, http.StatusOK, iface.ErrNotRegistered},
+		{"check missing", ttl, true, http.StatusNotFound, iface.ErrNotRegistered},
+		{"no ttl check", tcp, true, http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := newAgent(t, tt.checkStatus)
+			if tt.register {
+				assert.Nil(t, ns.Register(tt.service))
+			}
+			assert.Equal(t, tt.want, ns.UpdateTTL(tt.service.ServiceID()))
+		})
+	}
+}
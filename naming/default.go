@@ -3,8 +3,34 @@ package naming
 import (
 	"fmt"
 	"im/iface"
+	"os"
 )
 
+const (
+	// ProtocolUnix 同一主机上通过unix socket通信，Address为socket文件路径
+	ProtocolUnix = "unix"
+	// ProtocolPipe 同一进程内通过net.Pipe通信，用于测试与单机模式
+	ProtocolPipe = "pipe"
+)
+
+// KeyStartTime 服务启动的时间(毫秒)，同一个ServiceID重新注册时用于判断服务是否重启过
+const KeyStartTime = "start_time"
+
+// KeyHost 服务所在的主机名，unix socket服务只能被同一主机上的服务连接
+const KeyHost = "host"
+
+// KeyFrameVersion 服务支持的tcp帧格式的最高版本，没有时只支持旧格式
+const KeyFrameVersion = "frame_version"
+
+// Reachable unix socket服务只有在同一主机上才能连接
+func Reachable(s iface.ServiceRegistration) bool {
+	if s.GetProtocol() != ProtocolUnix {
+		return true
+	}
+	host, _ := os.Hostname()
+	return s.GetMeta()[KeyHost] == host
+}
+
 type DefaultService struct {
 	Id        string
 	Name      string
@@ -50,6 +76,9 @@ func (e *DefaultService) DialURL() string {
 	if e.Protocol == "tcp" {
 		return fmt.Sprintf("%s:%d", e.Address, e.Port)
 	}
+	if e.Protocol == ProtocolUnix || e.Protocol == ProtocolPipe {
+		return fmt.Sprintf("%s://%s", e.Protocol, e.Address)
+	}
 	return fmt.Sprintf("%s://%s:%d", e.Protocol, e.Address, e.Port)
 }
 
@@ -72,3 +101,23 @@ func (e *DefaultService) GetMeta() map[string]string { return e.Meta }
 func (e *DefaultService) String() string {
 	return fmt.Sprintf("Id:%s,Name:%s,Address:%s,Port:%d,Ns:%s,Tags:%v,Meta:%v", e.Id, e.Name, e.Address, e.Port, e.Namespace, e.Tags, e.Meta)
 }
+
+// Match 服务属于namespace并且带有所有tags，不同namespace的服务互相不可见
+func Match(s iface.ServiceRegistration, namespace string, tags []string) bool {
+	if s.GetNamespace() != namespace {
+		return false
+	}
+	for _, tag := range tags {
+		found := false
+		for _, t := range s.GetTags() {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
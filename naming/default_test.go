@@ -0,0 +1,29 @@
+package naming
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReachable(t *testing.T) {
+	host, err := os.Hostname()
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name string
+		s    *DefaultService
+		want bool
+	}{
+		{"tcp", &DefaultService{Protocol: "tcp"}, true},
+		{"unix on this host", &DefaultService{Protocol: ProtocolUnix, Meta: map[string]string{KeyHost: host}}, true},
+		{"unix on another host", &DefaultService{Protocol: ProtocolUnix, Meta: map[string]string{KeyHost: host + "-other"}}, false},
+		{"unix without host", &DefaultService{Protocol: ProtocolUnix}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Reachable(tt.s))
+		})
+	}
+}
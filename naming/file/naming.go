@@ -0,0 +1,195 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"im/iface"
+	"im/logger"
+	"im/naming"
+	"im/naming/memory"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultInterval 检查文件变化的默认间隔
+const DefaultInterval = time.Second * 2
+
+// Entry 文件中的一个服务节点
+type Entry struct {
+	ID        string            `yaml:"id" json:"id"`
+	Name      string            `yaml:"name" json:"name"`
+	Address   string            `yaml:"address" json:"address"`
+	Port      int               `yaml:"port" json:"port"`
+	Protocol  string            `yaml:"protocol" json:"protocol"`
+	Namespace string            `yaml:"namespace" json:"namespace"`
+	Tags      []string          `yaml:"tags" json:"tags"`
+	Meta      map[string]string `yaml:"meta" json:"meta"`
+}
+
+// Document 服务列表文件，根据扩展名使用json或yaml解析
+//
+//	services:
+//	  - id: chat01
+//	    name: chat
+//	    address: 127.0.0.1
+//	    port: 8005
+//	    protocol: tcp
+//	    tags: [zone-a]
+type Document struct {
+	Services []Entry `yaml:"services" json:"services"`
+}
+
+// Naming 从静态文件中读取服务列表，用于没有consul的小规模部署。
+// 文件变化时重新加载，只有增加、删除或修改的服务会回调订阅者。
+// Register注册的服务只保存在进程内，不会写回文件。
+type Naming struct {
+	iface.Naming
+	path     string
+	interval time.Duration
+
+	sync.Mutex
+	entries map[string]*naming.DefaultService //id -> service
+	modTime time.Time
+	size    int64
+	quit    chan struct{}
+	once    sync.Once
+}
+
+// NewNaming 加载文件并开始监听变化，interval为0时使用DefaultInterval
+func NewNaming(path string, interval time.Duration, opts ...memory.Option) (*Naming, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	n := &Naming{
+		Naming:   memory.NewNaming(opts...),
+		path:     path,
+		interval: interval,
+		entries:  make(map[string]*naming.DefaultService),
+		quit:     make(chan struct{}),
+	}
+	if err := n.Reload(); err != nil {
+		return nil, err
+	}
+	go n.watch()
+	return n, nil
+}
+
+// Reload 重新读取文件，与当前的服务列表比较后更新
+func (n *Naming) Reload() error {
+	info, err := os.Stat(n.path)
+	if err != nil {
+		return err
+	}
+	entries, err := Load(n.path)
+	if err != nil {
+		return err
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.modTime = info.ModTime()
+	n.size = info.Size()
+
+	for id := range n.entries {
+		if _, ok := entries[id]; !ok {
+			_ = n.Naming.Deregister(id)
+			logger.Infof("naming file %s: service %s removed", n.path, id)
+		}
+	}
+	for id, s := range entries {
+		if old, ok := n.entries[id]; ok && reflect.DeepEqual(old, s) {
+			continue
+		}
+		if err := n.Naming.Register(s); err != nil {
+			return err
+		}
+		logger.Infof("naming file %s: service %s(%s) updated", n.path, id, s.Name)
+	}
+	n.entries = entries
+	return nil
+}
+
+// Close 停止监听文件
+func (n *Naming) Close() {
+	n.once.Do(func() {
+		close(n.quit)
+	})
+}
+
+func (n *Naming) watch() {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.quit:
+			return
+		}
+		info, err := os.Stat(n.path)
+		if err != nil {
+			logger.Warnf("naming file %s: %v", n.path, err)
+			continue
+		}
+		n.Lock()
+		changed := !info.ModTime().Equal(n.modTime) || info.Size() != n.size
+		n.Unlock()
+		if !changed {
+			continue
+		}
+		// 解析失败时保留之前的服务列表
+		if err := n.Reload(); err != nil {
+			logger.Warnf("naming file %s: reload failed: %v", n.path, err)
+		}
+	}
+}
+
+// Load 读取并校验服务列表文件
+func Load(path string) (map[string]*naming.DefaultService, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// 与yaml一样不接受未知的字段，避免拼写错误的配置被忽略
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&doc)
+	default:
+		err = yaml.UnmarshalStrict(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	entries := make(map[string]*naming.DefaultService, len(doc.Services))
+	for i, e := range doc.Services {
+		if e.ID == "" || e.Name == "" {
+			return nil, fmt.Errorf("%s: services[%d]: id and name are required", path, i)
+		}
+		if _, ok := entries[e.ID]; ok {
+			return nil, fmt.Errorf("%s: services[%d]: duplicate id %s", path, i, e.ID)
+		}
+		if e.Meta == nil {
+			e.Meta = make(map[string]string)
+		}
+		entries[e.ID] = &naming.DefaultService{
+			Id:        e.ID,
+			Name:      e.Name,
+			Address:   e.Address,
+			Port:      e.Port,
+			Protocol:  e.Protocol,
+			Namespace: e.Namespace,
+			Tags:      e.Tags,
+			Meta:      e.Meta,
+		}
+	}
+	return entries, nil
+}
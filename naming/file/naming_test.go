@@ -0,0 +1,130 @@
+package file
+
+import (
+	"im/iface"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const services1 = `
+services:
+  - id: chat01
+    name: chat
+    address: 127.0.0.1
+    port: 8005
+    protocol: tcp
+    tags: [a]
+  - id: login01
+    name: login
+    address: 127.0.0.1
+    port: 8006
+    protocol: tcp
+`
+
+const services2 = `
+services:
+  - id: chat01
+    name: chat
+    address: 127.0.0.1
+    port: 8005
+    protocol: tcp
+    tags: [a]
+  - id: chat02
+    name: chat
+    address: 127.0.0.2
+    port: 8005
+    protocol: tcp
+    meta:
+      zone: b
+`
+
+func TestNaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "naming")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "services.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(services1), 0644))
+
+	ns, err := NewNaming(path, time.Millisecond*20)
+	assert.Nil(t, err)
+	defer ns.Close()
+
+	services, _ := ns.Find("chat", "a")
+	assert.Len(t, services, 1)
+
+	chat := make(chan []iface.ServiceRegistration, 10)
+	login := make(chan []iface.ServiceRegistration, 10)
+	_ = ns.Subscribe("chat", func(services []iface.ServiceRegistration) { chat <- services })
+	_ = ns.Subscribe("login", func(services []iface.ServiceRegistration) { login <- services })
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(services2), 0644))
+	select {
+	case services := <-chat:
+		assert.Len(t, services, 2)
+	case <-time.After(time.Second):
+		t.Fatal("chat not notified")
+	}
+	select {
+	case services := <-login:
+		assert.Empty(t, services)
+	case <-time.After(time.Second):
+		t.Fatal("login not notified")
+	}
+	services, _ = ns.Find("chat")
+	for _, s := range services {
+		if s.ServiceID() == "chat02" {
+			assert.Equal(t, "b", s.GetMeta()["zone"])
+		}
+	}
+
+	// 解析失败时保留之前的服务列表
+	assert.Nil(t, ioutil.WriteFile(path, []byte("services: [}"), 0644))
+	time.Sleep(time.Millisecond * 100)
+	services, _ = ns.Find("chat")
+	assert.Len(t, services, 2)
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		ids  []string
+		err  string
+	}{
+		{"json", "services.json", `{"services":[{"id":"chat01","name":"chat","address":"127.0.0.1","port":8005,"protocol":"tcp","meta":{"zone":"a"}}]}`, []string{"chat01"}, ""},
+		{"json unknown field", "services.json", `{"services":[{"id":"chat01","name":"chat","prot":"tcp"}]}`, nil, "unknown field"},
+		{"json missing id", "services.json", `{"services":[{"name":"chat"}]}`, nil, "id and name are required"},
+		{"json duplicate id", "services.json", `{"services":[{"id":"chat01","name":"chat"},{"id":"chat01","name":"login"}]}`, nil, "duplicate id chat01"},
+		{"yaml", "services.yaml", services1, []string{"chat01", "login01"}, ""},
+		{"yaml unknown field", "services.yaml", "services:\n  - id: chat01\n    name: chat\n    prot: tcp\n", nil, "not found"},
+		{"yaml missing name", "services.yaml", "services:\n  - id: chat01\n", nil, "id and name are required"},
+		{"yaml duplicate id", "services.yml", "services:\n  - id: chat01\n    name: chat\n  - id: chat01\n    name: chat\n", nil, "duplicate id chat01"},
+	}
+	dir, err := ioutil.TempDir("", "naming")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			assert.Nil(t, ioutil.WriteFile(path, []byte(tt.data), 0644))
+			entries, err := Load(path)
+			if tt.err != "" {
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Len(t, entries, len(tt.ids))
+			for _, id := range tt.ids {
+				assert.Equal(t, id, entries[id].ServiceID())
+				assert.NotNil(t, entries[id].GetMeta())
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"errors"
+	"im/iface"
+	"im/logger"
+	"im/naming"
+	"sync"
+)
+
+// Naming 进程内的服务注册与发现，用于测试与单机部署，语义与consul.Naming相同
+type Naming struct {
+	sync.RWMutex
+	namespace string
+	services  map[string]map[string]iface.ServiceRegistration //name -> id -> service
+	watches   map[string]*naming.Watch
+}
+
+type Option func(n *Naming)
+
+// WithNamespace 只发现namespace下的服务，默认为空
+func WithNamespace(namespace string) Option {
+	return func(n *Naming) {
+		n.namespace = namespace
+	}
+}
+
+func NewNaming(opts ...Option) iface.Naming {
+	n := &Naming{
+		services: make(map[string]map[string]iface.ServiceRegistration),
+		watches:  make(map[string]*naming.Watch),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Register 注册服务，相同的ServiceID会覆盖之前的注册
+func (n *Naming) Register(s iface.ServiceRegistration) error {
+	if s.ServiceID() == "" || s.ServiceName() == "" {
+		return errors.New("service id and name are required")
+	}
+	n.Lock()
+	// 同一个id换了服务名时从旧的服务中移除
+	for name, services := range n.services {
+		if _, ok := services[s.ServiceID()]; ok && name != s.ServiceName() {
+			delete(services, s.ServiceID())
+			n.notify(name)
+		}
+	}
+	services, ok := n.services[s.ServiceName()]
+	if !ok {
+		services = make(map[string]iface.ServiceRegistration)
+		n.services[s.ServiceName()] = services
+	}
+	services[s.ServiceID()] = naming.Clone(s)
+	n.notify(s.ServiceName())
+	n.Unlock()
+	return nil
+}
+
+func (n *Naming) Deregister(serviceID string) error {
+	n.Lock()
+	defer n.Unlock()
+	for name, services := range n.services {
+		if _, ok := services[serviceID]; ok {
+			delete(services, serviceID)
+			n.notify(name)
+		}
+	}
+	return nil
+}
+
+// Find 返回同一namespace下带有所有tags的服务
+func (n *Naming) Find(name string, tags ...string) ([]iface.ServiceRegistration, error) {
+	n.RLock()
+	defer n.RUnlock()
+	return n.find(name, tags...), nil
+}
+
+func (n *Naming) find(name string, tags ...string) []iface.ServiceRegistration {
+	services := make([]iface.ServiceRegistration, 0, len(n.services[name]))
+	for _, s := range n.services[name] {
+		if naming.Match(s, n.namespace, tags) {
+			services = append(services, naming.Clone(s))
+		}
+	}
+	return services
+}
+
+// Subscribe 服务有变化时回调，回调在单独的协程中按顺序执行
+func (n *Naming) Subscribe(serviceName string, callback func([]iface.ServiceRegistration), tags ...string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if _, ok := n.watches[serviceName]; ok {
+		return errors.New("serviceName has already been registered")
+	}
+	w := naming.NewWatch(serviceName, callback, tags...)
+	n.watches[serviceName] = w
+	go n.watch(w)
+	return nil
+}
+
+func (n *Naming) UnSubscribe(serviceName string) error {
+	n.Lock()
+	defer n.Unlock()
+	wh, ok := n.watches[serviceName]
+	if ok {
+		delete(n.watches, serviceName)
+		close(wh.Quit)
+	}
+	return nil
+}
+
+// notify 需要持有锁，多次变化合并为一次回调
+func (n *Naming) notify(name string) {
+	wh, ok := n.watches[name]
+	if !ok {
+		return
+	}
+	wh.Notify()
+}
+
+func (n *Naming) watch(wh *naming.Watch) {
+	for {
+		select {
+		case <-wh.Notified():
+		case <-wh.Quit:
+			logger.Infof("watch %s stopped", wh.Service)
+			return
+		}
+		n.RLock()
+		services := n.find(wh.Service, wh.Tags...)
+		n.RUnlock()
+		if wh.Callback != nil {
+			wh.Callback(services)
+		}
+	}
+}
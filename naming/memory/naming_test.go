@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"im/iface"
+	"im/naming"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNaming(t *testing.T) {
+	ns := NewNaming()
+
+	notified := make(chan []iface.ServiceRegistration, 10)
+	err := ns.Subscribe("chat", func(services []iface.ServiceRegistration) {
+		notified <- services
+	})
+	assert.Nil(t, err)
+
+	s1 := &naming.DefaultService{Id: "chat01", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8001, Tags: []string{"a", "b"}}
+	s2 := &naming.DefaultService{Id: "chat02", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8002, Tags: []string{"a"}}
+	assert.Nil(t, ns.Register(s1))
+	assert.Nil(t, ns.Register(s2))
+
+	services, _ := ns.Find("chat")
+	assert.Len(t, services, 2)
+	services, _ = ns.Find("chat", "a", "b")
+	assert.Len(t, services, 1)
+	assert.Equal(t, "chat01", services[0].ServiceID())
+
+	// 修改返回的meta不影响注册信息
+	services[0].GetMeta()["state"] = "adult"
+	services, _ = ns.Find("chat", "b")
+	assert.Empty(t, services[0].GetMeta()["state"])
+
+	assert.Nil(t, ns.Deregister("chat01"))
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case services := <-notified:
+				if len(services) == 1 && services[0].ServiceID() == "chat02" {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, time.Second, time.Millisecond*10)
+
+	assert.NotNil(t, ns.Subscribe("chat", nil))
+	assert.Nil(t, ns.UnSubscribe("chat"))
+}
+
+func TestNamingNamespace(t *testing.T) {
+	ns := NewNaming(WithNamespace("prod"))
+
+	notified := make(chan []iface.ServiceRegistration, 10)
+	err := ns.Subscribe("chat", func(services []iface.ServiceRegistration) {
+		notified <- services
+	}, "a")
+	assert.Nil(t, err)
+
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat01", Name: "chat", Namespace: "prod", Tags: []string{"a"}}))
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat02", Name: "chat", Namespace: "staging", Tags: []string{"a"}}))
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat03", Name: "chat", Namespace: "prod"}))
+
+	services, _ := ns.Find("chat")
+	assert.Len(t, services, 2)
+	services, _ = ns.Find("chat", "a")
+	assert.Len(t, services, 1)
+	assert.Equal(t, "chat01", services[0].ServiceID())
+
+	select {
+	case services := <-notified:
+		for _, s := range services {
+			assert.Equal(t, "chat01", s.ServiceID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not notified")
+	}
+}
@@ -0,0 +1,308 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"im/iface"
+	"im/logger"
+	"im/naming"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rds "github.com/go-redis/redis/v7"
+)
+
+// DefaultTTL 注册信息的存活时间，由心跳按TTL/3续期
+const DefaultTTL = time.Second * 10
+
+// 服务名下的节点保存在zset中，score为过期时间(毫秒)；节点信息单独保存并设置TTL
+const (
+	keyServices = "naming:services:%s" // name -> zset(id)
+	keyService  = "naming:service:%s"  // id -> json
+	keyEvents   = "naming:events:"     // pub/sub channel前缀
+)
+
+func KeyServices(name string) string {
+	return fmt.Sprintf(keyServices, name)
+}
+
+func KeyService(id string) string {
+	return fmt.Sprintf(keyService, id)
+}
+
+func KeyEvents(name string) string {
+	return keyEvents + name
+}
+
+// Naming 基于redis的服务注册与发现。
+// 注册后由心跳续期，进程崩溃时节点在TTL之后过期；注册与注销通过pub/sub通知订阅者，
+// 订阅者同时按TTL定时检查，以发现过期的节点。
+type Naming struct {
+	sync.RWMutex
+	cli        *rds.Client
+	ttl        time.Duration
+	namespace  string
+	heartbeats map[string]chan struct{} //id -> quit
+	watches    map[string]*naming.Watch
+	pubsub     *rds.PubSub
+}
+
+type Option func(n *Naming)
+
+// WithNamespace 只发现namespace下的服务，默认为空
+func WithNamespace(namespace string) Option {
+	return func(n *Naming) {
+		n.namespace = namespace
+	}
+}
+
+// NewNaming ttl为0时使用DefaultTTL
+func NewNaming(cli *rds.Client, ttl time.Duration, opts ...Option) *Naming {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	n := &Naming{
+		cli:        cli,
+		ttl:        ttl,
+		heartbeats: make(map[string]chan struct{}),
+		watches:    make(map[string]*naming.Watch),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Register 注册服务并开始心跳，相同的ServiceID会覆盖之前的注册
+func (n *Naming) Register(s iface.ServiceRegistration) error {
+	if s.ServiceID() == "" || s.ServiceName() == "" {
+		return errors.New("service id and name are required")
+	}
+	entry := naming.Clone(s)
+	if err := n.write(entry); err != nil {
+		return err
+	}
+	if err := n.cli.Publish(KeyEvents(entry.Name), entry.Id).Err(); err != nil {
+		return err
+	}
+
+	n.Lock()
+	if quit, ok := n.heartbeats[entry.Id]; ok {
+		close(quit)
+	}
+	quit := make(chan struct{})
+	n.heartbeats[entry.Id] = quit
+	n.Unlock()
+	go n.heartbeat(entry, quit)
+	return nil
+}
+
+// write 写入节点信息并刷新过期时间，redis数据丢失时心跳会重新写入
+func (n *Naming) write(s *naming.DefaultService) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(n.ttl).UnixNano() / int64(time.Millisecond)
+	pipe := n.cli.TxPipeline()
+	pipe.Set(KeyService(s.Id), buf, n.ttl)
+	pipe.ZAdd(KeyServices(s.Name), &rds.Z{Score: float64(deadline), Member: s.Id})
+	_, err = pipe.Exec()
+	return err
+}
+
+func (n *Naming) heartbeat(s *naming.DefaultService, quit chan struct{}) {
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+		if err := n.write(s); err != nil {
+			logger.Warnf("naming heartbeat %s: %v", s.Id, err)
+		}
+	}
+}
+
+func (n *Naming) Deregister(serviceID string) error {
+	n.Lock()
+	if quit, ok := n.heartbeats[serviceID]; ok {
+		delete(n.heartbeats, serviceID)
+		close(quit)
+	}
+	n.Unlock()
+
+	buf, err := n.cli.Get(KeyService(serviceID)).Bytes()
+	if err == rds.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s naming.DefaultService
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return err
+	}
+	pipe := n.cli.TxPipeline()
+	pipe.Del(KeyService(serviceID))
+	pipe.ZRem(KeyServices(s.Name), serviceID)
+	pipe.Publish(KeyEvents(s.Name), serviceID)
+	_, err = pipe.Exec()
+	return err
+}
+
+// Find 返回同一namespace下存活且带有所有tags的服务
+func (n *Naming) Find(name string, tags ...string) ([]iface.ServiceRegistration, error) {
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	// 清理已经过期的节点
+	n.cli.ZRemRangeByScore(KeyServices(name), "-inf", "("+now)
+
+	ids, err := n.cli.ZRangeByScore(KeyServices(name), &rds.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	services := make([]iface.ServiceRegistration, 0, len(ids))
+	if len(ids) == 0 {
+		return services, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = KeyService(id)
+	}
+	values, err := n.cli.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var s naming.DefaultService
+		if err := json.Unmarshal([]byte(str), &s); err != nil {
+			logger.Warnf("naming find %s: %v", name, err)
+			continue
+		}
+		// 同一个id换了服务名时，旧的zset中的记录在过期前会被跳过
+		if s.Name == name && naming.Match(&s, n.namespace, tags) {
+			services = append(services, &s)
+		}
+	}
+	return services, nil
+}
+
+// Subscribe 订阅时回调当前的服务列表，之后服务有注册、注销或节点过期时回调最新的服务列表
+func (n *Naming) Subscribe(serviceName string, callback func([]iface.ServiceRegistration), tags ...string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if _, ok := n.watches[serviceName]; ok {
+		return errors.New("serviceName has already been registered")
+	}
+	if n.pubsub == nil {
+		n.pubsub = n.cli.PSubscribe(keyEvents + "*")
+		go n.dispatch(n.pubsub.Channel())
+	}
+	w := naming.NewWatch(serviceName, callback, tags...)
+	n.watches[serviceName] = w
+	go n.watch(w)
+	return nil
+}
+
+func (n *Naming) UnSubscribe(serviceName string) error {
+	n.Lock()
+	defer n.Unlock()
+	wh, ok := n.watches[serviceName]
+	if ok {
+		delete(n.watches, serviceName)
+		close(wh.Quit)
+	}
+	return nil
+}
+
+// Close 停止心跳与订阅，已注册的节点在TTL之后过期
+func (n *Naming) Close() error {
+	n.Lock()
+	defer n.Unlock()
+	for id, quit := range n.heartbeats {
+		delete(n.heartbeats, id)
+		close(quit)
+	}
+	for name, wh := range n.watches {
+		delete(n.watches, name)
+		close(wh.Quit)
+	}
+	if n.pubsub != nil {
+		return n.pubsub.Close()
+	}
+	return nil
+}
+
+func (n *Naming) dispatch(ch <-chan *rds.Message) {
+	for msg := range ch {
+		name := strings.TrimPrefix(msg.Channel, keyEvents)
+		n.RLock()
+		wh, ok := n.watches[name]
+		n.RUnlock()
+		if !ok {
+			continue
+		}
+		wh.Notify()
+	}
+}
+
+func (n *Naming) watch(wh *naming.Watch) {
+	// 订阅时先回调一次当前的服务列表
+	last, err := n.Find(wh.Service, wh.Tags...)
+	if err != nil {
+		logger.Warn(err)
+	} else if wh.Callback != nil {
+		wh.Callback(last)
+	}
+	ticker := time.NewTicker(n.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wh.Notified():
+		case <-ticker.C:
+		case <-wh.Quit:
+			logger.Infof("watch %s stopped", wh.Service)
+			return
+		}
+		services, err := n.Find(wh.Service, wh.Tags...)
+		if err != nil {
+			logger.Warn(err)
+			continue
+		}
+		if equal(last, services) {
+			continue
+		}
+		last = services
+		if wh.Callback != nil {
+			wh.Callback(services)
+		}
+	}
+}
+
+// equal 比较两次的节点列表，节点的地址、协议、tags或meta变化都视为不同
+func equal(a, b []iface.ServiceRegistration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	services := make(map[string]iface.ServiceRegistration, len(a))
+	for _, s := range a {
+		services[s.ServiceID()] = s
+	}
+	for _, s := range b {
+		if old, ok := services[s.ServiceID()]; !ok || !reflect.DeepEqual(old, s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"im/iface"
+	"im/naming"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	rds "github.com/go-redis/redis/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+func newNaming(t *testing.T, ttl time.Duration, opts ...Option) (*Naming, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	return NewNaming(rds.NewClient(&rds.Options{Addr: mr.Addr()}), ttl, opts...), mr
+}
+
+func TestNamingRegister(t *testing.T) {
+	ns, mr := newNaming(t, time.Second)
+	defer mr.Close()
+	defer ns.Close()
+
+	s1 := &naming.DefaultService{Id: "chat01", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8001, Tags: []string{"a", "b"}}
+	s2 := &naming.DefaultService{Id: "chat02", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8002, Tags: []string{"a"}}
+	assert.Nil(t, ns.Register(s1))
+	assert.Nil(t, ns.Register(s2))
+	assert.NotNil(t, ns.Register(&naming.DefaultService{Name: "chat"}))
+
+	services, err := ns.Find("chat")
+	assert.Nil(t, err)
+	assert.Len(t, services, 2)
+	services, err = ns.Find("chat", "a", "b")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "chat01", services[0].ServiceID())
+	assert.Equal(t, "127.0.0.1:8001", services[0].DialURL())
+
+	assert.Nil(t, ns.Deregister("chat01"))
+	assert.Nil(t, ns.Deregister("chat01"))
+	services, err = ns.Find("chat")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "chat02", services[0].ServiceID())
+}
+
+func TestNamingNamespace(t *testing.T) {
+	ns, mr := newNaming(t, time.Second, WithNamespace("prod"))
+	defer mr.Close()
+	defer ns.Close()
+
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat01", Name: "chat", Namespace: "prod"}))
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat02", Name: "chat", Namespace: "dev"}))
+	services, err := ns.Find("chat")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "chat01", services[0].ServiceID())
+}
+
+// 进程崩溃时没有注销，节点在TTL之后过期，订阅者定时检查时发现
+func TestNamingExpire(t *testing.T) {
+	ttl := time.Millisecond * 300
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+	registry := NewNaming(rds.NewClient(&rds.Options{Addr: mr.Addr()}), ttl)
+	watcher := NewNaming(rds.NewClient(&rds.Options{Addr: mr.Addr()}), ttl)
+	defer watcher.Close()
+
+	assert.Nil(t, registry.Register(&naming.DefaultService{Id: "chat01", Name: "chat"}))
+	// 心跳续期，超过TTL仍然存活
+	time.Sleep(ttl * 2)
+	services, err := watcher.Find("chat")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+
+	notified := make(chan []iface.ServiceRegistration, 10)
+	assert.Nil(t, watcher.Subscribe("chat", func(services []iface.ServiceRegistration) {
+		notified <- services
+	}))
+	// 订阅时回调当前的服务列表
+	select {
+	case services := <-notified:
+		assert.Len(t, services, 1)
+	case <-time.After(ttl * 5):
+		t.Fatal("current services is not notified")
+	}
+	// 停止心跳
+	assert.Nil(t, registry.Close())
+	select {
+	case services := <-notified:
+		assert.Empty(t, services)
+	case <-time.After(ttl * 5):
+		t.Fatal("expired service is not notified")
+	}
+}
+
+func TestNamingSubscribe(t *testing.T) {
+	ns, mr := newNaming(t, time.Second*10)
+	defer mr.Close()
+	defer ns.Close()
+
+	notified := make(chan []iface.ServiceRegistration, 10)
+	assert.Nil(t, ns.Subscribe("chat", func(services []iface.ServiceRegistration) {
+		notified <- services
+	}))
+	assert.NotNil(t, ns.Subscribe("chat", nil))
+	wait := func() []iface.ServiceRegistration {
+		select {
+		case services := <-notified:
+			return services
+		case <-time.After(time.Second * 2):
+			t.Fatal("wait for notify timeout")
+		}
+		return nil
+	}
+	// 等待订阅生效
+	assert.Eventually(t, func() bool {
+		return mr.PubSubNumPat() > 0
+	}, time.Second, time.Millisecond*10)
+	assert.Empty(t, wait())
+
+	s1 := &naming.DefaultService{Id: "chat01", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8001}
+	assert.Nil(t, ns.Register(s1))
+	services := wait()
+	assert.Len(t, services, 1)
+
+	// 地址不变，meta变化也需要通知
+	s1.Meta = map[string]string{"zone": "b"}
+	assert.Nil(t, ns.Register(s1))
+	services = wait()
+	assert.Equal(t, "b", services[0].GetMeta()["zone"])
+
+	assert.Nil(t, ns.Deregister("chat01"))
+	assert.Empty(t, wait())
+
+	assert.Nil(t, ns.UnSubscribe("chat"))
+}
+
+// 订阅之前已经注册的服务在订阅时回调
+func TestNamingSubscribeCurrent(t *testing.T) {
+	ns, mr := newNaming(t, time.Second*10)
+	defer mr.Close()
+	defer ns.Close()
+
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat01", Name: "chat", Tags: []string{"a"}}))
+	assert.Nil(t, ns.Register(&naming.DefaultService{Id: "chat02", Name: "chat"}))
+
+	notified := make(chan []iface.ServiceRegistration, 10)
+	assert.Nil(t, ns.Subscribe("chat", func(services []iface.ServiceRegistration) {
+		notified <- services
+	}, "a"))
+	select {
+	case services := <-notified:
+		assert.Len(t, services, 1)
+		assert.Equal(t, "chat01", services[0].ServiceID())
+	case <-time.After(time.Second * 2):
+		t.Fatal("current services is not notified")
+	}
+}
+
+// redis不可用时注册返回错误
+func TestNamingRegisterFailed(t *testing.T) {
+	ns, mr := newNaming(t, time.Second)
+	defer ns.Close()
+	mr.Close()
+
+	assert.NotNil(t, ns.Register(&naming.DefaultService{Id: "chat01", Name: "chat"}))
+}
+
+func TestEqual(t *testing.T) {
+	base := &naming.DefaultService{Id: "chat01", Name: "chat", Protocol: "tcp", Address: "127.0.0.1", Port: 8001, Tags: []string{"a"}, Meta: map[string]string{"k": "v"}}
+	with := func(f func(s *naming.DefaultService)) iface.ServiceRegistration {
+		s := *base
+		f(&s)
+		return &s
+	}
+	tests := []struct {
+		name string
+		b    iface.ServiceRegistration
+		want bool
+	}{
+		{"same", with(func(s *naming.DefaultService) {}), true},
+		{"id", with(func(s *naming.DefaultService) { s.Id = "chat02" }), false},
+		{"port", with(func(s *naming.DefaultService) { s.Port = 8002 }), false},
+		{"protocol", with(func(s *naming.DefaultService) { s.Protocol = "ws" }), false},
+		{"tags", with(func(s *naming.DefaultService) { s.Tags = []string{"b"} }), false},
+		{"meta", with(func(s *naming.DefaultService) { s.Meta = map[string]string{"k": "w"} }), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, equal([]iface.ServiceRegistration{base}, []iface.ServiceRegistration{tt.b}))
+		})
+	}
+	assert.False(t, equal([]iface.ServiceRegistration{base}, nil))
+}
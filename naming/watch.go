@@ -0,0 +1,53 @@
+package naming
+
+import "im/iface"
+
+// Watch 一个服务的订阅，变化时只保证回调最新的服务列表
+type Watch struct {
+	Service  string
+	Tags     []string
+	Callback func([]iface.ServiceRegistration)
+	Quit     chan struct{}
+	notify   chan struct{}
+}
+
+func NewWatch(service string, callback func([]iface.ServiceRegistration), tags ...string) *Watch {
+	return &Watch{
+		Service:  service,
+		Tags:     tags,
+		Callback: callback,
+		Quit:     make(chan struct{}),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Notify 服务有变化，多次变化合并为一次回调，不会阻塞
+func (w *Watch) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Notified 有变化需要重新查询时可读
+func (w *Watch) Notified() <-chan struct{} {
+	return w.notify
+}
+
+// Clone 复制注册信息，每次返回新的对象，调用方修改meta不会影响注册信息
+func Clone(s iface.ServiceRegistration) *DefaultService {
+	meta := make(map[string]string, len(s.GetMeta()))
+	for k, v := range s.GetMeta() {
+		meta[k] = v
+	}
+	return &DefaultService{
+		Id:        s.ServiceID(),
+		Name:      s.ServiceName(),
+		Address:   s.PublicAddress(),
+		Port:      s.PublicPort(),
+		Protocol:  s.GetProtocol(),
+		Namespace: s.GetNamespace(),
+		Tags:      append([]string(nil), s.GetTags()...),
+		Meta:      meta,
+	}
+}
@@ -0,0 +1,37 @@
+package pipe
+
+import (
+	"im/iface"
+	"im/tcp"
+	"net"
+)
+
+// NewClient 进程内的客户端，需要配合Dialer使用
+func NewClient(id, name string, opts tcp.ClientOptions) iface.IClient {
+	cli := tcp.NewClient(id, name, opts)
+	cli.SetDialer(&Dialer{})
+	return cli
+}
+
+// Dialer 在进程内拨号，Handshake为空时把DialerContext.Id作为握手包发送
+type Dialer struct {
+	Handshake func(conn iface.IConn, ctx iface.DialerContext) error
+}
+
+func (d *Dialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
+	raw, err := DialTimeout("pipe", ctx.Address, ctx.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := tcp.NewTcpConn(raw)
+	if d.Handshake != nil {
+		err = d.Handshake(conn, ctx)
+	} else {
+		err = conn.WriteFrame(iface.OpBinary, []byte(ctx.Id))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,114 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"im/core"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prefix = "pipe://"
+
+var (
+	ErrListenerClosed = errors.New("pipe: listener closed")
+	ErrDialTimeout    = errors.New("pipe: dial timeout")
+)
+
+// listeners 进程内所有的监听，key为地址
+var listeners sync.Map
+
+type addr string
+
+func (a addr) Network() string { return "pipe" }
+func (a addr) String() string  { return string(a) }
+
+// conn net.Pipe的写入是同步的，长度为0的写入也会阻塞到对端读取，
+// 而空payload的帧会产生这样的写入，因此直接跳过
+type conn struct {
+	net.Conn
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return c.Conn.Write(b)
+}
+
+type listener struct {
+	address string
+	conns   chan net.Conn
+	closed  *core.Event
+}
+
+// Listen 在进程内监听address，签名与net.Listen相同，network被忽略
+func Listen(network, address string) (net.Listener, error) {
+	address = strings.TrimPrefix(address, prefix)
+	lis := &listener{
+		address: address,
+		conns:   make(chan net.Conn),
+		closed:  core.NewEvent(),
+	}
+	if _, loaded := listeners.LoadOrStore(address, lis); loaded {
+		return nil, fmt.Errorf("pipe: address %s already in use", address)
+	}
+	return lis, nil
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed.Done():
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *listener) Close() error {
+	if l.closed.Fire() {
+		listeners.Delete(l.address)
+	}
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return addr(l.address)
+}
+
+// Dial 连接进程内的address
+func Dial(address string) (net.Conn, error) {
+	return DialTimeout("pipe", address, 0)
+}
+
+// DialTimeout 签名与net.DialTimeout相同，network被忽略，timeout为0时不超时
+func DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	address = strings.TrimPrefix(address, prefix)
+	val, ok := listeners.Load(address)
+	if !ok {
+		return nil, fmt.Errorf("pipe: dial %s: connection refused", address)
+	}
+	lis := val.(*listener)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	cli, srv := net.Pipe()
+	select {
+	case lis.conns <- &conn{srv}:
+		return &conn{cli}, nil
+	case <-lis.closed.Done():
+	case <-expired:
+		cli.Close()
+		srv.Close()
+		return nil, ErrDialTimeout
+	}
+	cli.Close()
+	srv.Close()
+	return nil, ErrListenerClosed
+}
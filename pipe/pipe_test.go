@@ -0,0 +1,73 @@
+package pipe
+
+import (
+	"context"
+	"im/iface"
+	"im/naming"
+	"im/tcp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type echoHandler struct{}
+
+func (h *echoHandler) Receive(ag iface.IAgent, payload []byte) {
+	_ = ag.Push(payload)
+}
+
+func (h *echoHandler) Disconnect(id string) error {
+	return nil
+}
+
+// Accept 第一帧为握手包，内容是客户端id
+func (h *echoHandler) Accept(conn iface.IConn, timeout time.Duration) (string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	return string(frame.GetPayload()), nil
+}
+
+func TestPipeEcho(t *testing.T) {
+	const address = "test.echo"
+	srv := NewServer(address, naming.NewEntry("echo01", "echo", naming.ProtocolPipe, address, 0))
+	srv.SetAcceptor(&echoHandler{})
+	srv.SetMessageListener(&echoHandler{})
+	srv.SetStateListener(&echoHandler{})
+	started := make(chan error, 1)
+	go func() {
+		started <- srv.Start()
+	}()
+	// Shutdown之后Start返回nil，并释放地址
+	defer func() {
+		_ = srv.Shutdown(context.Background())
+		assert.Nil(t, <-started)
+		_, ok := listeners.Load(address)
+		assert.False(t, ok)
+	}()
+
+	// 等待监听就绪，地址被占用时Start直接返回错误
+	assert.Eventually(t, func() bool {
+		_, ok := listeners.Load(address)
+		return ok
+	}, time.Second, time.Millisecond*10)
+	select {
+	case err := <-started:
+		t.Fatal(err)
+	default:
+	}
+
+	cli := NewClient("cli01", "test", tcp.ClientOptions{})
+	err := cli.Connect("pipe://" + address)
+	assert.Nil(t, err)
+	defer cli.Close()
+
+	err = cli.Send([]byte("hello"))
+	assert.Nil(t, err)
+	frame, err := cli.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(frame.GetPayload()))
+}
@@ -0,0 +1,255 @@
+package pipe
+
+import (
+	"bytes"
+	"im/container"
+	"im/core"
+	"im/iface"
+	"im/naming"
+	"im/naming/memory"
+	gateserv "im/services/gateway/serv"
+	"im/services/server/handler"
+	logicserv "im/services/server/serv"
+	"im/storage"
+	"im/tcp"
+	"im/wire/device"
+	"im/wire/presence"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire"
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/klintcheng/kim/wire/token"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	gateID       = "gate01"
+	gateAddress  = "scenario.gate01"
+	logicAddress = "scenario.chat01"
+)
+
+// logicDispatcher 与container.Push相同，container已经被网关使用，逻辑服务直接通过server推送到网关
+type logicDispatcher struct {
+	srv iface.IServer
+}
+
+func (d *logicDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	p.AddStringMeta(wire.MetaDestChannels, strings.Join(channels, ","))
+	p.AddStringMeta(wire.MetaDestServer, gateway)
+	return d.srv.Push(gateway, pkt.Marshal(p))
+}
+
+var (
+	setupOnce sync.Once
+	sessions  *storage.MemoryStorage
+)
+
+// setup 在进程内启动逻辑服务与网关。container是单例，多次运行测试时只启动一次
+func setup(t *testing.T) *storage.MemoryStorage {
+	setupOnce.Do(func() {
+		ns := memory.NewNaming()
+
+		// 逻辑服务，每种设备类型保留一个会话
+		sessions = storage.NewMemoryStorage(storage.WithDevicePolicy(storage.DevicePerType))
+		// 场景中的账号可以互相订阅在线状态
+		allowAll := handler.PresenceAuthorizerFunc(func(app, subscriber string, accounts []string) error {
+			return nil
+		})
+		presenceHandler := handler.NewPresenceHandler(storage.NewMemoryPresence(), handler.WithAuthorizer(allowAll))
+		loginHandler := handler.NewLoginHandler(presenceHandler)
+		r := core.NewRouter()
+		r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
+		r.Handle(wire.CommandLoginSignOut, loginHandler.DoSysLogout)
+		r.Handle(presence.CommandQuery, presenceHandler.DoQuery)
+		r.Handle(presence.CommandSubscribe, presenceHandler.DoSubscribe)
+
+		logicService := &naming.DefaultService{
+			Id:       "chat01",
+			Name:     wire.SNChat,
+			Protocol: naming.ProtocolPipe,
+			Address:  logicAddress,
+			// 网关与逻辑服务之间使用带版本的帧格式
+			Meta: map[string]string{naming.KeyFrameVersion: strconv.Itoa(int(tcp.ProtocolVersion))},
+		}
+		logicSrv := NewServer(logicAddress, logicService)
+		servhandler := logicserv.NewServHandler(r, sessions)
+		servhandler.SetDispatcher(&logicDispatcher{srv: logicSrv})
+		logicSrv.SetAcceptor(servhandler)
+		logicSrv.SetMessageListener(servhandler)
+		logicSrv.SetStateListener(servhandler)
+		go func() {
+			_ = logicSrv.Start()
+		}()
+		assert.Eventually(t, func() bool {
+			_, ok := listeners.Load(logicAddress)
+			return ok
+		}, time.Second, time.Millisecond*10)
+		assert.Nil(t, ns.Register(logicService))
+
+		// 网关
+		gateSrv := NewServer(gateAddress, &naming.DefaultService{
+			Id:       gateID,
+			Name:     "gateway",
+			Protocol: naming.ProtocolPipe,
+			Address:  gateAddress,
+			Meta:     map[string]string{},
+		})
+		gateHandler := &gateserv.Handler{ServiceID: gateID}
+		gateSrv.SetAcceptor(gateHandler)
+		gateSrv.SetMessageListener(gateHandler)
+		gateSrv.SetStateListener(gateHandler)
+		assert.Nil(t, container.Init(gateSrv, wire.SNChat))
+		container.SetServiceNaming(ns)
+		container.SetDialer(&gateserv.TcpDialer{
+			ServiceID: gateID,
+			Flags:     tcp.FlagCompression,
+			DialFunc:  DialTimeout,
+		})
+		go func() {
+			_ = container.Start()
+		}()
+
+		// 网关已经连接到逻辑服务
+		assert.Eventually(t, func() bool {
+			_, ok := listeners.Load(gateAddress)
+			return ok && logicSrv.Push(gateID, nil) == nil
+		}, time.Second*3, time.Millisecond*10)
+	})
+	return sessions
+}
+
+type user struct {
+	iface.IClient
+	packets chan *pkt.LogicPkt
+}
+
+// login 通过网关登录，等待逻辑服务保存会话
+func login(t *testing.T, account string) *user {
+	return loginDevice(t, account, "")
+}
+
+// loginDevice 以device设备类型登录，device为空时不携带设备类型
+func loginDevice(t *testing.T, account, deviceType string) *user {
+	tk, err := token.Generate(token.DefaultSecret, &token.Token{
+		Account: account,
+		App:     "kim",
+		Exp:     time.Now().Add(time.Hour).Unix(),
+	})
+	assert.Nil(t, err)
+	cli := NewClient(account, "sdk", tcp.ClientOptions{})
+	cli.SetDialer(&Dialer{
+		Handshake: func(conn iface.IConn, ctx iface.DialerContext) error {
+			req := pkt.New(wire.CommandLoginSignIn)
+			loginReq := &pkt.LoginReq{Token: tk}
+			if deviceType != "" {
+				loginReq.Tags = []string{device.Tag(deviceType)}
+			}
+			req.WriteBody(loginReq)
+			return conn.WriteFrame(iface.OpBinary, pkt.Marshal(req))
+		},
+	})
+	assert.Nil(t, cli.Connect("pipe://"+gateAddress))
+
+	u := &user{IClient: cli, packets: make(chan *pkt.LogicPkt, 16)}
+	go func() {
+		defer close(u.packets)
+		for {
+			frame, err := cli.Read()
+			if err != nil {
+				return
+			}
+			packet, err := pkt.MustReadLogicPkt(bytes.NewBuffer(frame.GetPayload()))
+			if err == nil {
+				u.packets <- packet
+			}
+		}
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := sessions.GetLocation(account, deviceType)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	return u
+}
+
+func (u *user) send(t *testing.T, command string, body proto.Message) {
+	req := pkt.New(command)
+	req.WriteBody(body)
+	assert.Nil(t, u.Send(pkt.Marshal(req)))
+}
+
+// expect 跳过其它消息，直到收到command
+func (u *user) expect(t *testing.T, command string, flag pkt.Flag) *pkt.LogicPkt {
+	timeout := time.After(time.Second * 2)
+	for {
+		select {
+		case packet, ok := <-u.packets:
+			if !ok {
+				t.Fatalf("%s closed before %s", u.ServiceID(), command)
+			}
+			if packet.Command == command && packet.Flag == flag {
+				return packet
+			}
+		case <-timeout:
+			t.Fatalf("%s wait for %s timeout", u.ServiceID(), command)
+		}
+	}
+}
+
+// TestGatewayLogic 客户端 -> 网关 -> 逻辑服务 -> 网关 -> 客户端
+func TestGatewayLogic(t *testing.T) {
+	sessions := setup(t)
+
+	alice := login(t, "alice")
+	defer alice.Close()
+	bob := login(t, "bob")
+
+	alice.send(t, presence.CommandQuery, &presence.QueryReq{Accounts: []string{"bob"}})
+	resp := alice.expect(t, presence.CommandQuery, pkt.Flag_Response)
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	var query presence.QueryResp
+	assert.Nil(t, resp.ReadBody(&query))
+	assert.True(t, query.Statuses[0].Online)
+
+	alice.send(t, presence.CommandSubscribe, &presence.SubscribeReq{Accounts: []string{"bob"}})
+	alice.expect(t, presence.CommandSubscribe, pkt.Flag_Response)
+
+	// bob断开后网关通知逻辑服务注销，订阅者收到离线通知
+	bob.Close()
+	notify := alice.expect(t, presence.CommandNotify, pkt.Flag_Push)
+	var status presence.Status
+	assert.Nil(t, notify.ReadBody(&status))
+	assert.Equal(t, "bob", status.Account)
+	assert.False(t, status.Online)
+	locs, err := sessions.GetLocations("bob")
+	assert.Nil(t, err)
+	assert.Empty(t, locs)
+}
+
+// TestGatewayDevices 同一账号在手机与平板上登录，设备类型经网关写入会话，两个会话都保持在线
+func TestGatewayDevices(t *testing.T) {
+	sessions := setup(t)
+
+	phone := loginDevice(t, "carol", "iphone")
+	defer phone.Close()
+	pad := loginDevice(t, "carol", "ipad")
+	defer pad.Close()
+
+	locs, err := sessions.GetLocations("carol")
+	assert.Nil(t, err)
+	assert.Len(t, locs, 2)
+
+	// 平板登录没有踢掉手机，两个连接都可以继续请求
+	for _, u := range []*user{phone, pad} {
+		u.send(t, presence.CommandQuery, &presence.QueryReq{Accounts: []string{"carol"}})
+		resp := u.expect(t, presence.CommandQuery, pkt.Flag_Response)
+		assert.Equal(t, pkt.Status_Success, resp.Status)
+	}
+	locs, err = sessions.GetLocations("carol")
+	assert.Nil(t, err)
+	assert.Len(t, locs, 2)
+}
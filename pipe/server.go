@@ -0,0 +1,12 @@
+package pipe
+
+import (
+	"im/iface"
+	"im/tcp"
+)
+
+// NewServer 在进程内监听address，帧格式与tcp.Server相同
+func NewServer(address string, service iface.ServiceRegistration, opts ...tcp.Option) iface.IServer {
+	opts = append(opts, tcp.WithListenFunc(Listen))
+	return tcp.NewServer(address, service, opts...)
+}
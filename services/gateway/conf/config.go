@@ -2,7 +2,10 @@ package conf
 
 import (
 	"fmt"
+	"log"
+	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/klintcheng/kim/logger"
 	"github.com/spf13/viper"
@@ -14,10 +17,55 @@ type Config struct {
 	ServiceName   string   `envconfig:"serviceName"`
 	Namespace     string   `envconfig:"namespace"`
 	Listen        string   `envconfig:"listen"`
+	TCPListen     string   `envconfig:"tcpListen"`
+	SSEListen     string   `envconfig:"sseListen"`
 	PublicAddress string   `envconfig:"publicAddress"`
 	PublicPort    int      `envconfig:"publicPort"`
 	Tags          []string `envconfig:"tags"`
 	ConsulURL     string   `envconfig:"consulURL"`
+	// protocol为all时tcp监听在注册中心中的端口，为0时使用TCPListen的端口
+	TCPPublicPort int `envconfig:"tcpPublicPort"`
+	// 静态服务列表文件(yaml或json)，不为空时代替consul做服务发现
+	NamingFile string `envconfig:"namingFile"`
+	// 使用redis做服务发现的地址，NamingFile为空时生效
+	NamingRedis string `envconfig:"namingRedis"`
+	// consul中服务的ttl健康检查，检查失败超过DeregisterCriticalAfter后注销，为0时使用默认值
+	HealthTTL               time.Duration `envconfig:"healthTTL"`
+	DeregisterCriticalAfter time.Duration `envconfig:"deregisterCriticalAfter"`
+	// 连接活跃时通知逻辑服务刷新会话的间隔，需要小于逻辑服务中会话的过期时间，为0时使用默认值
+	SessionKeepAlive time.Duration `envconfig:"sessionKeepAlive"`
+	// websocket升级配置，AllowedOrigins同时用于sse的跨域校验
+	WsPath         string   `envconfig:"wsPath"`
+	AllowedOrigins []string `envconfig:"allowedOrigins"`
+	UpgradeAuth    bool     `envconfig:"upgradeAuth"`
+	// permessage-deflate压缩，WsCompressThreshold为0时使用默认阈值
+	WsCompression       bool `envconfig:"wsCompression"`
+	WsCompressThreshold int  `envconfig:"wsCompressThreshold"`
+	// 消息帧最大长度，为0时使用默认值
+	MaxPayload int `envconfig:"maxPayload"`
+	// 连接数限制，所有协议共用，为0时不限制
+	MaxConns      int `envconfig:"maxConns"`
+	MaxConnsPerIP int `envconfig:"maxConnsPerIP"`
+	MaxHandshakes int `envconfig:"maxHandshakes"`
+	// tcp监听开启PROXY protocol，ws信任TrustedProxies发送的X-Forwarded-For
+	ProxyProtocol  bool     `envconfig:"proxyProtocol"`
+	TrustedProxies []string `envconfig:"trustedProxies"`
+	// tcp监听使用事件循环模式(仅linux)，EventLoopWorkers为0时使用cpu核数
+	EventLoop        bool `envconfig:"eventLoop"`
+	EventLoopWorkers int  `envconfig:"eventLoopWorkers"`
+	// 连接的写缓冲大小，为0时使用默认值，小于0时不使用缓冲
+	WriteBuffer int `envconfig:"writeBuffer"`
+	// 对外监听的tls配置
+	TLSEnable       bool   `envconfig:"tlsEnable"`
+	TLSCertFile     string `envconfig:"tlsCertFile"`
+	TLSKeyFile      string `envconfig:"tlsKeyFile"`
+	TLSClientCAFile string `envconfig:"tlsClientCAFile"`
+	// 连接逻辑服务时始终使用旧的帧格式，为false时根据逻辑服务注册的帧格式版本选择
+	LegacyFrame bool `envconfig:"legacyFrame"`
+	// 连接逻辑服务的tls配置，ServiceCAFile不为空时开启
+	ServiceCAFile   string `envconfig:"serviceCAFile"`
+	ServiceCertFile string `envconfig:"serviceCertFile"`
+	ServiceKeyFile  string `envconfig:"serviceKeyFile"`
 }
 
 // Init InitConfig
@@ -43,3 +91,20 @@ func Init(file string) (*Config, error) {
 
 	return &config, nil
 }
+
+func InitRedis(addr string, pass string) (*redis.Client, error) {
+	redisdb := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     pass,
+		DialTimeout:  time.Second * 5,
+		ReadTimeout:  time.Second * 5,
+		WriteTimeout: time.Second * 5,
+	})
+
+	_, err := redisdb.Ping().Result()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return redisdb, nil
+}
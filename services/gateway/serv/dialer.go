@@ -1,10 +1,13 @@
 package serv
 
 import (
+	"crypto/tls"
 	"im/iface"
 	"im/logger"
+	"im/naming"
 	"im/tcp"
 	"net"
+	"time"
 
 	"github.com/klintcheng/kim/wire/pkt"
 	"google.golang.org/protobuf/proto"
@@ -12,18 +15,39 @@ import (
 
 type TcpDialer struct {
 	ServiceID string
+	// Legacy 为true时始终使用旧的帧格式。为false时只有逻辑服务注册了naming.KeyFrameVersion
+	// 才使用带版本的格式，滚动升级中还没有升级的逻辑服务仍然使用旧格式
+	Legacy bool
+	// Flags 握手时提供的能力
+	Flags uint8
+	// DialFunc 为空时使用net.DialTimeout，测试中可以替换为pipe.DialTimeout
+	DialFunc func(network, address string, timeout time.Duration) (net.Conn, error)
 }
 
-func NewDialer(serviceId string) iface.IDialer {
+// NewDialer legacy为true时始终使用旧的帧格式
+func NewDialer(serviceId string, legacy bool) iface.IDialer {
 	return &TcpDialer{
 		ServiceID: serviceId,
+		Legacy:    legacy,
+		Flags:     tcp.FlagCompression,
 	}
 }
 
 // DialAndHandshake(context.Context, string) (net.Conn, error)
 func (d *TcpDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
 	// 1. 拨号建立连接
-	conn, err := net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+	var (
+		conn net.Conn
+		err  error
+	)
+	network, address := tcp.ParseAddress(ctx.Address)
+	if ctx.TLSConfig != nil && network == "tcp" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: ctx.Timeout}, network, address, ctx.TLSConfig)
+	} else if d.DialFunc != nil {
+		conn, err = d.DialFunc(network, address, ctx.Timeout)
+	} else {
+		conn, err = net.DialTimeout(network, address, ctx.Timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -33,9 +57,23 @@ func (d *TcpDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error)
 	logger.Infof("send req %v", req)
 	// 2. 把自己的ServiceId发送给对方
 	bts, _ := proto.Marshal(req)
-	err = tcp.WriteFrame(conn, iface.OpBinary, bts)
+	if d.Legacy || ctx.Meta[naming.KeyFrameVersion] == "" {
+		err = tcp.WriteFrame(conn, iface.OpBinary, bts)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	// 3. 带版本的帧格式，等待服务端的回复，握手完成时双方的能力已经确定
+	vconn := tcp.NewVersionedConn(conn, d.Flags)
+	err = vconn.WriteFrame(iface.OpBinary, bts)
+	if err == nil {
+		err = vconn.WaitHandshakeAck(ctx.Timeout)
+	}
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
-	return conn, nil
+	return vconn, nil
 }
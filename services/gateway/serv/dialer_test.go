@@ -0,0 +1,73 @@
+package serv
+
+import (
+	"im/iface"
+	"im/naming"
+	"im/tcp"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// acceptOnce 以逻辑服务的方式读取网关的握手并回复
+func acceptOnce(t *testing.T, lis net.Listener) chan *tcp.TcpConn {
+	accepted := make(chan *tcp.TcpConn, 1)
+	go func() {
+		raw, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		conn := tcp.NewServerConn(raw, tcp.ModeAuto, tcp.SupportedFlags, 0)
+		frame, err := conn.ReadFrame()
+		assert.Nil(t, err)
+		var req pkt.InnerHandshakeReq
+		assert.Nil(t, proto.Unmarshal(frame.GetPayload(), &req))
+		assert.Equal(t, "gate01", req.ServiceId)
+		assert.Nil(t, conn.AckHandshake())
+		accepted <- conn
+	}()
+	return accepted
+}
+
+func TestDialerFrameFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		legacy  bool
+		meta    map[string]string
+		version uint8
+		flags   uint8
+	}{
+		{"logic server without frame version", false, nil, 0, 0},
+		{"logic server with frame version", false, map[string]string{naming.KeyFrameVersion: "1"}, tcp.ProtocolVersion, tcp.FlagCompression},
+		{"forced legacy", true, map[string]string{naming.KeyFrameVersion: "1"}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.Nil(t, err)
+			defer lis.Close()
+			accepted := acceptOnce(t, lis)
+
+			d := NewDialer("gate01", tt.legacy)
+			conn, err := d.DialAndHandshake(iface.DialerContext{
+				Address: lis.Addr().String(),
+				Timeout: time.Second,
+				Meta:    tt.meta,
+			})
+			assert.Nil(t, err)
+			defer conn.Close()
+			server := <-accepted
+			assert.Equal(t, tt.version, server.Version())
+			// 握手返回时能力已经确定
+			if vconn, ok := conn.(*tcp.TcpConn); ok {
+				assert.Equal(t, tt.flags, vconn.Flags())
+			} else {
+				assert.Zero(t, tt.flags)
+			}
+		})
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"im/container"
 	"im/iface"
 	"im/logger"
+	"im/wire/device"
+	"net/http"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/klintcheng/kim/wire"
@@ -19,8 +22,14 @@ var log = logger.WithFields(logger.Fields{
 	"pkg":     "serv",
 })
 
+// DefaultKeepAlive 连接活跃时通知逻辑服务刷新会话的默认间隔，需要小于会话的过期时间
+const DefaultKeepAlive = time.Minute * 3
+
 type Handler struct {
 	ServiceID string
+	// KeepAlive 为0时使用DefaultKeepAlive
+	KeepAlive time.Duration
+	active    sync.Map //channelId -> 最近一次刷新会话的时间
 }
 
 func (h *Handler) Accept(conn iface.IConn, timeout time.Duration) (string, error) {
@@ -57,6 +66,12 @@ func (h *Handler) Accept(conn iface.IConn, timeout time.Duration) (string, error
 	}
 
 	tk, err := token.Parse(token.DefaultSecret, login.Token)
+	if err == nil {
+		// 升级时已经鉴权的连接，不允许使用其它账号的token登录
+		if ac, ok := conn.(iface.IAuthConn); ok && ac.Identity() != "" && ac.Identity() != tk.Account {
+			err = fmt.Errorf("login account %s mismatches the authenticated account %s", tk.Account, ac.Identity())
+		}
+	}
 	if err != nil {
 		resp := pkt.NewFrom(&req.Header)
 		resp.Status = pkt.Status_Unauthorized
@@ -71,14 +86,28 @@ func (h *Handler) Accept(conn iface.IConn, timeout time.Duration) (string, error
 		GateId:    h.ServiceID,
 		App:       tk.App,
 		RemoteIP:  getIP(conn.RemoteAddr().String()),
+		Device:    device.FromLogin(&login),
 	})
 	err = container.Forward(wire.SNLogin, req)
 	if err != nil {
 		return "", err
 	}
+	h.active.Store(id, time.Now())
 	return id, nil
 }
 
+// Authenticate websocket升级前校验token，失败时以401拒绝，成功时返回token中的账号
+func (h *Handler) Authenticate(r *http.Request, tk string) (string, int, error) {
+	if tk == "" {
+		return "", http.StatusUnauthorized, fmt.Errorf("token is required")
+	}
+	t, err := token.Parse(token.DefaultSecret, tk)
+	if err != nil {
+		return "", http.StatusUnauthorized, err
+	}
+	return t.Account, http.StatusOK, nil
+}
+
 func (h *Handler) Receive(ag iface.IAgent, payload []byte) {
 	buf := bytes.NewBuffer(payload)
 	packet, err := pkt.Read(buf)
@@ -89,12 +118,14 @@ func (h *Handler) Receive(ag iface.IAgent, payload []byte) {
 	if basicPkt, ok := packet.(*pkt.BasicPkt); ok {
 		if basicPkt.Code == pkt.CodePing {
 			ag.Push(pkt.Marshal(&pkt.BasicPkt{Code: pkt.CodePong}))
+			h.keepalive(ag.ID())
 		}
 		return
 	}
 
 	if logicPkt, ok := packet.(*pkt.LogicPkt); ok {
 		logicPkt.ChannelId = ag.ID()
+		h.keepalive(ag.ID())
 
 		err = container.Forward(logicPkt.ServiceName(), logicPkt)
 		if err != nil {
@@ -108,8 +139,31 @@ func (h *Handler) Receive(ag iface.IAgent, payload []byte) {
 	}
 }
 
+// Heartbeat 传输层的ping与pong同样刷新会话，空闲但是连接正常的客户端不会过期
+func (h *Handler) Heartbeat(ag iface.IAgent) {
+	h.keepalive(ag.ID())
+}
+
+// keepalive 连接有心跳或消息时，按KeepAlive的间隔通知逻辑服务刷新会话
+func (h *Handler) keepalive(id string) {
+	interval := h.KeepAlive
+	if interval <= 0 {
+		interval = DefaultKeepAlive
+	}
+	now := time.Now()
+	if last, ok := h.active.Load(id); ok && now.Sub(last.(time.Time)) < interval {
+		return
+	}
+	h.active.Store(id, now)
+	packet := pkt.New(iface.CommandSessionKeepAlive, pkt.WithChannel(id))
+	if err := container.Forward(wire.SNLogin, packet); err != nil {
+		log.WithField("id", id).Warn(err)
+	}
+}
+
 func (h *Handler) Disconnect(id string) error {
 	log.Infof("disconnect %s", id)
+	h.active.Delete(id)
 	logout := pkt.New(wire.CommandLoginSignOut, pkt.WithChannel(id))
 	err := container.Forward(wire.SNLogin, logout)
 	if err != nil {
@@ -0,0 +1,65 @@
+package serv
+
+import (
+	"bytes"
+	"im/iface"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire"
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/klintcheng/kim/wire/token"
+	"github.com/stretchr/testify/assert"
+)
+
+type frame struct {
+	payload []byte
+}
+
+func (f *frame) SetOpCode(iface.OpCode)  {}
+func (f *frame) GetOpCode() iface.OpCode { return iface.OpBinary }
+func (f *frame) SetPayload(p []byte)     { f.payload = p }
+func (f *frame) GetPayload() []byte      { return f.payload }
+
+// authConn 升级时已经以identity鉴权的连接
+type authConn struct {
+	net.Conn
+	identity string
+	in       []byte
+	out      [][]byte
+}
+
+func (c *authConn) ReadFrame() (iface.IFrame, error) {
+	return &frame{payload: c.in}, nil
+}
+
+func (c *authConn) WriteFrame(_ iface.OpCode, p []byte) error {
+	c.out = append(c.out, p)
+	return nil
+}
+
+func (c *authConn) Flush() error                      { return nil }
+func (c *authConn) SetReadDeadline(t time.Time) error { return nil }
+func (c *authConn) Identity() string                  { return c.identity }
+
+func TestAcceptIdentityMismatch(t *testing.T) {
+	tk, err := token.Generate(token.DefaultSecret, &token.Token{
+		Account: "b",
+		App:     "kim",
+		Exp:     time.Now().Add(time.Hour).Unix(),
+	})
+	assert.Nil(t, err)
+	login := pkt.New(wire.CommandLoginSignIn)
+	login.WriteBody(&pkt.LoginReq{Token: tk})
+
+	conn := &authConn{identity: "a", in: pkt.Marshal(login)}
+	h := &Handler{ServiceID: "gate01"}
+	_, err = h.Accept(conn, time.Second)
+	assert.NotNil(t, err)
+
+	assert.Len(t, conn.out, 1)
+	resp, err := pkt.MustReadLogicPkt(bytes.NewBuffer(conn.out[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, pkt.Status_Unauthorized, resp.Status)
+}
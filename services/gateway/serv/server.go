@@ -0,0 +1,111 @@
+package serv
+
+import (
+	"context"
+	"errors"
+	"im/core"
+	"im/iface"
+	"time"
+)
+
+// MultiServer 在一个网关进程中同时运行多个监听(如ws与tcp)
+// 所有监听共享同一个ChannelMap，对container而言只是一个IServer
+type MultiServer struct {
+	iface.ServiceRegistration
+	servers    []iface.IServer
+	ChannelMap iface.IChannelMap
+}
+
+// shutdownWait 一个server退出后等待其它server关闭的时间
+const shutdownWait = time.Second * 10
+
+// NewMultiServer 第一个server的服务信息作为容器中的服务信息
+func NewMultiServer(servers ...iface.IServer) iface.IServer {
+	if len(servers) == 0 {
+		return nil
+	}
+	ms := &MultiServer{
+		ServiceRegistration: servers[0],
+		servers:             servers,
+	}
+	ms.SetChannelMap(core.NewChannels(100))
+	return ms
+}
+
+// Registrations 返回所有server中不重复的服务信息，每个监听都可以被服务发现
+func (ms *MultiServer) Registrations() []iface.ServiceRegistration {
+	seen := make(map[string]struct{}, len(ms.servers))
+	var list []iface.ServiceRegistration
+	for _, srv := range ms.servers {
+		if _, ok := seen[srv.ServiceID()]; ok {
+			continue
+		}
+		seen[srv.ServiceID()] = struct{}{}
+		list = append(list, srv)
+	}
+	return list
+}
+
+// Start 启动所有server，任意一个退出时关闭其它server并返回它的结果
+func (ms *MultiServer) Start() error {
+	errc := make(chan error, len(ms.servers))
+	for _, srv := range ms.servers {
+		go func(srv iface.IServer) {
+			errc <- srv.Start()
+		}(srv)
+	}
+	err := <-errc
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownWait)
+	defer cancel()
+	_ = ms.Shutdown(ctx)
+	return err
+}
+
+func (ms *MultiServer) Push(id string, payload []byte) error {
+	ch, ok := ms.ChannelMap.Get(id)
+	if !ok {
+		return errors.New("channel:" + id + " not found")
+	}
+	return ch.Push(payload)
+}
+
+func (ms *MultiServer) Shutdown(ctx context.Context) error {
+	var err error
+	for _, srv := range ms.servers {
+		if e := srv.Shutdown(ctx); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (ms *MultiServer) SetAcceptor(acceptor iface.IAcceptor) {
+	for _, srv := range ms.servers {
+		srv.SetAcceptor(acceptor)
+	}
+}
+
+func (ms *MultiServer) SetMessageListener(listener iface.IMessageListener) {
+	for _, srv := range ms.servers {
+		srv.SetMessageListener(listener)
+	}
+}
+
+func (ms *MultiServer) SetStateListener(listener iface.IStatelistener) {
+	for _, srv := range ms.servers {
+		srv.SetStateListener(listener)
+	}
+}
+
+func (ms *MultiServer) SetReadWait(readwait time.Duration) {
+	for _, srv := range ms.servers {
+		srv.SetReadWait(readwait)
+	}
+}
+
+func (ms *MultiServer) SetChannelMap(channelMap iface.IChannelMap) {
+	ms.ChannelMap = channelMap
+	for _, srv := range ms.servers {
+		srv.SetChannelMap(channelMap)
+	}
+}
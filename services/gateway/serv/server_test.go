@@ -0,0 +1,89 @@
+package serv
+
+import (
+	"context"
+	"im/iface"
+	"im/naming"
+	"im/tcp"
+	"im/websocket"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stateListener struct{}
+
+func (stateListener) Disconnect(string) error { return nil }
+
+// freeAddr 返回一个当前没有被占用的本地地址
+func freeAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := lis.Addr().String()
+	_ = lis.Close()
+	return addr
+}
+
+func newMultiServer(wsAddr, tcpAddr string) iface.IServer {
+	ws := websocket.NewServer(wsAddr, &naming.DefaultService{Id: "gate01", Protocol: "ws"})
+	ts := tcp.NewServer(tcpAddr, &naming.DefaultService{Id: "gate01-tcp", Protocol: "tcp"})
+	srv := NewMultiServer(ws, ts)
+	srv.SetStateListener(stateListener{})
+	return srv
+}
+
+func listening(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, time.Millisecond*100)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+func TestMultiServer(t *testing.T) {
+	wsAddr, tcpAddr := freeAddr(t), freeAddr(t)
+	srv := newMultiServer(wsAddr, tcpAddr)
+
+	regs := srv.(iface.IMultiRegistration).Registrations()
+	assert.Len(t, regs, 2)
+	assert.Equal(t, "gate01", regs[0].ServiceID())
+	assert.Equal(t, "gate01-tcp", regs[1].ServiceID())
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Start()
+	}()
+	assert.Eventually(t, func() bool {
+		return listening(wsAddr) && listening(tcpAddr)
+	}, time.Second, time.Millisecond*10)
+
+	assert.Nil(t, srv.Shutdown(context.Background()))
+	assert.Nil(t, <-errc)
+	assert.False(t, listening(wsAddr))
+	assert.False(t, listening(tcpAddr))
+}
+
+func TestMultiServerStartFailed(t *testing.T) {
+	// tcp的地址已经被占用
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer busy.Close()
+	wsAddr := freeAddr(t)
+	srv := newMultiServer(wsAddr, busy.Addr().String())
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Start()
+	}()
+	select {
+	case err = <-errc:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second * 3):
+		t.Fatal("Start did not return")
+	}
+	// ws监听随之关闭
+	assert.False(t, listening(wsAddr))
+}
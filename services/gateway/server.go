@@ -2,20 +2,38 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
 	"im/container"
+	"im/core"
 	"im/iface"
 	"im/logger"
 	"im/naming"
 	"im/naming/consul"
+	"im/naming/file"
+	"im/naming/memory"
+	redisnaming "im/naming/redis"
 	"im/services/gateway/conf"
 	"im/services/gateway/serv"
+	"im/sse"
+	"im/tcp"
 	"im/websocket"
+	"net"
+	"strconv"
 	"time"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/klintcheng/kim/wire"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+const (
+	ProtocolWS  = "ws"
+	ProtocolTCP = "tcp"
+	// ProtocolAll 同时启动ws与tcp监听
+	ProtocolAll = "all"
+)
+
 type ServerStartOptions struct {
 	config   string
 	protocol string
@@ -32,7 +50,7 @@ func NewServerStartCmd(ctx context.Context, version string) *cobra.Command {
 		},
 	}
 	cmd.PersistentFlags().StringVarP(&opts.config, "config", "c", "./gateway/conf.yaml", "Config file")
-	cmd.PersistentFlags().StringVarP(&opts.protocol, "protocol", "p", "ws", "protocol of ws or tcp")
+	cmd.PersistentFlags().StringVarP(&opts.protocol, "protocol", "p", "ws", "protocol of ws, tcp or all")
 	return cmd
 }
 
@@ -47,19 +65,31 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 
 	handler := &serv.Handler{
 		ServiceID: config.ServiceID,
+		KeepAlive: config.SessionKeepAlive,
 	}
 
-	var srv iface.IServer
 	service := &naming.DefaultService{
-		Id:       config.ServiceID,
-		Name:     config.ServiceName,
-		Address:  config.PublicAddress,
-		Port:     config.PublicPort,
-		Protocol: opts.protocol,
-		Tags:     config.Tags,
+		Id:        config.ServiceID,
+		Name:      config.ServiceName,
+		Address:   config.PublicAddress,
+		Port:      config.PublicPort,
+		Namespace: config.Namespace,
+		Protocol:  opts.protocol,
+		Tags:      config.Tags,
+		Meta: map[string]string{
+			naming.KeyStartTime: strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+		},
+	}
+	var tlsConfig *tls.Config
+	if config.TLSEnable {
+		tlsConfig, err = core.NewServerTLSConfig(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			return err
+		}
 	}
-	if opts.protocol == "ws" {
-		srv = websocket.NewServer(config.Listen, service)
+	srv, err := newServer(opts.protocol, config, service, tlsConfig, handler)
+	if err != nil {
+		return err
 	}
 	srv.SetReadWait(time.Minute * 2)
 	srv.SetAcceptor(handler)
@@ -67,12 +97,153 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	srv.SetStateListener(handler)
 
 	container.Init(srv, wire.SNChat, wire.SNLogin)
-	ns, err := consul.NewNaming(config.ConsulURL)
+	var ns iface.Naming
+	if config.NamingFile != "" {
+		ns, err = file.NewNaming(config.NamingFile, 0, memory.WithNamespace(config.Namespace))
+	} else if config.NamingRedis != "" {
+		var cli *redis.Client
+		if cli, err = conf.InitRedis(config.NamingRedis, ""); err == nil {
+			ns = redisnaming.NewNaming(cli, 0, redisnaming.WithNamespace(config.Namespace))
+		}
+	} else {
+		consulOpts := []consul.Option{consul.WithNamespace(config.Namespace)}
+		if config.HealthTTL > 0 {
+			consulOpts = append(consulOpts, consul.WithTTL(config.HealthTTL))
+		}
+		if config.DeregisterCriticalAfter > 0 {
+			consulOpts = append(consulOpts, consul.WithDeregisterCriticalAfter(config.DeregisterCriticalAfter))
+		}
+		ns, err = consul.NewNaming(config.ConsulURL, consulOpts...)
+	}
 	if err != nil {
 		return err
 	}
 	container.SetServiceNaming(ns)
-	container.SetDialer(serv.NewDialer(config.ServiceID))
+	container.SetDialer(serv.NewDialer(config.ServiceID, config.LegacyFrame))
+	if config.ServiceCAFile != "" {
+		cliConfig, err := core.NewClientTLSConfig(config.ServiceCAFile, config.ServiceCertFile, config.ServiceKeyFile)
+		if err != nil {
+			return err
+		}
+		container.SetTLSConfig(cliConfig)
+	}
 
 	return container.Start()
 }
+
+// newServer 根据protocol构造网关server，all模式下ws与tcp共享同一个ChannelMap
+func newServer(protocol string, config *conf.Config, service *naming.DefaultService, tlsConfig *tls.Config, handler *serv.Handler) (iface.IServer, error) {
+	var (
+		wsOpts  []websocket.Option
+		tcpOpts []tcp.Option
+		sseOpts []sse.Option
+	)
+	limiter := core.NewConnLimiter("gateway", core.ConnLimits{
+		MaxConns:      config.MaxConns,
+		MaxConnsPerIP: config.MaxConnsPerIP,
+		MaxHandshakes: config.MaxHandshakes,
+	})
+	wsOpts = append(wsOpts, websocket.WithConnLimiter(limiter))
+	tcpOpts = append(tcpOpts, tcp.WithConnLimiter(limiter))
+	sseOpts = append(sseOpts, sse.WithConnLimiter(limiter))
+	trusted, err := core.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted) > 0 {
+		wsOpts = append(wsOpts, websocket.WithTrustedProxies(trusted))
+	}
+	if config.ProxyProtocol {
+		tcpOpts = append(tcpOpts, tcp.WithProxyProtocol(trusted))
+	}
+	if config.EventLoop {
+		tcpOpts = append(tcpOpts, tcp.WithEventLoop(config.EventLoopWorkers))
+	}
+	if config.WriteBuffer != 0 {
+		wsOpts = append(wsOpts, websocket.WithWriteBuffer(config.WriteBuffer))
+		tcpOpts = append(tcpOpts, tcp.WithWriteBuffer(config.WriteBuffer))
+	}
+	if config.MaxPayload > 0 {
+		wsOpts = append(wsOpts, websocket.WithMaxPayload(config.MaxPayload))
+		tcpOpts = append(tcpOpts, tcp.WithMaxPayload(config.MaxPayload))
+		sseOpts = append(sseOpts, sse.WithMaxPayload(config.MaxPayload))
+	}
+	if config.WsPath != "" {
+		wsOpts = append(wsOpts, websocket.WithPath(config.WsPath))
+	}
+	if len(config.AllowedOrigins) > 0 {
+		wsOpts = append(wsOpts, websocket.WithAllowedOrigins(config.AllowedOrigins...))
+		sseOpts = append(sseOpts, sse.WithAllowedOrigins(config.AllowedOrigins...))
+	}
+	if config.WsCompression {
+		threshold := config.WsCompressThreshold
+		if threshold == 0 {
+			threshold = websocket.DefaultCompressThreshold
+		}
+		wsOpts = append(wsOpts, websocket.WithCompression(threshold))
+	}
+	if config.UpgradeAuth {
+		wsOpts = append(wsOpts, websocket.WithAuth(handler.Authenticate))
+	}
+	if tlsConfig != nil {
+		wsOpts = append(wsOpts, websocket.WithTLSConfig(tlsConfig))
+		tcpOpts = append(tcpOpts, tcp.WithTLSConfig(tlsConfig))
+		sseOpts = append(sseOpts, sse.WithTLSConfig(tlsConfig))
+	}
+
+	var servers []iface.IServer
+	switch protocol {
+	case ProtocolWS:
+		servers = append(servers, websocket.NewServer(config.Listen, service, wsOpts...))
+	case ProtocolTCP:
+		servers = append(servers, tcp.NewServer(config.Listen, service, tcpOpts...))
+	case ProtocolAll:
+		if config.TCPListen == "" {
+			return nil, errors.New("TCPListen is required in protocol all")
+		}
+		// ws与tcp分别注册，tcp监听以ServiceID-tcp注册，会话中的网关ID仍然是ServiceID
+		service.Protocol = ProtocolWS
+		tcpService, err := tcpRegistration(service, config)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers,
+			websocket.NewServer(config.Listen, service, wsOpts...),
+			tcp.NewServer(config.TCPListen, tcpService, tcpOpts...),
+		)
+	default:
+		return nil, errors.Errorf("unsupported protocol: %s", protocol)
+	}
+	// 无法升级websocket的客户端使用sse
+	if config.SSEListen != "" {
+		servers = append(servers, sse.NewServer(config.SSEListen, service, sseOpts...))
+	}
+	if len(servers) == 1 {
+		return servers[0], nil
+	}
+	return serv.NewMultiServer(servers...), nil
+}
+
+// tcpRegistration 返回protocol为all时tcp监听的注册信息
+func tcpRegistration(service *naming.DefaultService, config *conf.Config) (*naming.DefaultService, error) {
+	port := config.TCPPublicPort
+	if port == 0 {
+		_, p, err := net.SplitHostPort(config.TCPListen)
+		if err != nil {
+			return nil, errors.Wrap(err, "TCPListen")
+		}
+		if port, err = strconv.Atoi(p); err != nil {
+			return nil, errors.Wrap(err, "TCPListen")
+		}
+	}
+	meta := make(map[string]string, len(service.Meta))
+	for k, v := range service.Meta {
+		meta[k] = v
+	}
+	tcpService := *service
+	tcpService.Id = service.Id + "-tcp"
+	tcpService.Protocol = ProtocolTCP
+	tcpService.Port = port
+	tcpService.Meta = meta
+	return &tcpService, nil
+}
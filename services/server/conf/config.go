@@ -24,8 +24,34 @@ type Config struct {
 	PublicPort    int      `envconfig:"publicPort"`
 	Tags          []string `envconfig:"tags"`
 	ConsulURL     string   `envconfig:"consulURL"`
-	RedisAddrs    string   `envconfig:"redisAddrs"`
-	RpcURL        string   `envconfig:"ppcURL"`
+	// 静态服务列表文件(yaml或json)，不为空时代替consul做服务发现
+	NamingFile string `envconfig:"namingFile"`
+	// 使用redis做服务发现的地址，NamingFile为空时生效
+	NamingRedis string `envconfig:"namingRedis"`
+	// consul中服务的ttl健康检查，检查失败超过DeregisterCriticalAfter后注销，为0时使用默认值
+	HealthTTL               time.Duration `envconfig:"healthTTL"`
+	DeregisterCriticalAfter time.Duration `envconfig:"deregisterCriticalAfter"`
+	RedisAddrs              string        `envconfig:"redisAddrs"`
+	// 会话存储，只支持redis。login与chat是各自独立的服务，进程内的存储互相不可见
+	SessionStorage string `envconfig:"sessionStorage"`
+	// 会话的过期时间，为0时使用storage.LocationExpired
+	SessionExpired time.Duration `envconfig:"sessionExpired"`
+	// 清理过期会话并通知在线状态订阅者的间隔，为0时使用serv.DefaultSweepInterval
+	SessionSweep time.Duration `envconfig:"sessionSweep"`
+	// 观察的网关服务名，网关崩溃或重启时清理它的会话，为空时使用wgateway与tgateway
+	Gateways []string `envconfig:"gateways"`
+	// 网关从注册中心消失多久之后清理会话，为0时使用默认值
+	GatewayGrace time.Duration `envconfig:"gatewayGrace"`
+	// 多设备登录策略：single、device、platform、unlimited，默认single
+	DevicePolicy string `envconfig:"devicePolicy"`
+	RpcURL       string `envconfig:"ppcURL"`
+	// 消息帧最大长度，为0时使用默认值
+	MaxPayload int `envconfig:"maxPayload"`
+	// tls配置，TLSClientCAFile不为空时校验网关的客户端证书
+	TLSEnable       bool   `envconfig:"tlsEnable"`
+	TLSCertFile     string `envconfig:"tlsCertFile"`
+	TLSKeyFile      string `envconfig:"tlsKeyFile"`
+	TLSClientCAFile string `envconfig:"tlsClientCAFile"`
 }
 
 // Init InitConfig
@@ -47,6 +73,10 @@ func Init(file string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	// storage.MemoryStorage只用于测试，login与chat各自一份时查不到对方创建的会话
+	if config.SessionStorage != "" && config.SessionStorage != "redis" {
+		return nil, fmt.Errorf("unsupported session storage %s, only redis is supported", config.SessionStorage)
+	}
 	logger.Info(config)
 
 	return &config, nil
@@ -0,0 +1,36 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitSessionStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		wantErr bool
+	}{
+		{"default", "", false},
+		{"redis", "redis", false},
+		{"memory", "memory", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "conf.yaml")
+			data := "ServiceID: chat01\nSessionStorage: \"" + tt.storage + "\"\n"
+			assert.Nil(t, os.WriteFile(file, []byte(data), 0644))
+
+			config, err := Init(file)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, "chat01", config.ServiceID)
+		})
+	}
+}
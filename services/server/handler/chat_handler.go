@@ -38,10 +38,10 @@ func (h *ChatHandler) DoUserTalk(ctx iface.IContext) {
 		ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
 		return
 	}
-	//获取接收方信息
+	//获取接收方在所有设备上的位置
 	receiver := ctx.Header().GetDest()
-	loc, err := ctx.GetLocation(receiver, "")
-	if err != nil && err != iface.ErrSessionNil {
+	locs, err := ctx.GetLocations(receiver)
+	if err != nil {
 		ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
@@ -63,7 +63,7 @@ func (h *ChatHandler) DoUserTalk(ctx iface.IContext) {
 	}
 	msgId := resp.MessageId
 	//如果接收方在线，发送消息
-	if loc != nil {
+	if len(locs) > 0 {
 		if err = ctx.Dispatch(&pkt.MessagePush{
 			MessageId: msgId,
 			Type:      req.GetType(),
@@ -71,7 +71,7 @@ func (h *ChatHandler) DoUserTalk(ctx iface.IContext) {
 			Extra:     req.GetExtra(),
 			Sender:    ctx.Session().GetAccount(),
 			SendTime:  sendTime,
-		}, loc); err != nil {
+		}, locs...); err != nil {
 			ctx.RespWithError(pkt.Status_SystemException, err)
 			return
 		}
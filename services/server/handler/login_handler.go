@@ -9,10 +9,14 @@ import (
 )
 
 type LoginHandler struct {
+	presence *PresenceHandler
 }
 
-func NewLoginHandler() *LoginHandler {
-	return &LoginHandler{}
+// NewLoginHandler presence不为空时在登录与注销时通知在线状态的订阅者
+func NewLoginHandler(presence *PresenceHandler) *LoginHandler {
+	return &LoginHandler{
+		presence: presence,
+	}
 }
 
 func (h *LoginHandler) DoSysLogin(ctx iface.IContext) {
@@ -29,8 +33,8 @@ func (h *LoginHandler) DoSysLogin(ctx iface.IContext) {
 		"RemoteIP":  session.GetRemoteIP(),
 	}).Info("do login")
 
-	//检测当前用户是否在其他地方登录
-	old, err := ctx.GetLocation(session.Account, "")
+	//检测当前用户是否在同一类设备上登录，由会话的设备策略决定
+	old, err := ctx.GetLocation(session.Account, session.Device)
 	if err != nil && err != iface.ErrSessionNil {
 		ctx.RespWithError(pkt.Status_SystemException, iface.ErrSessionNil)
 		return
@@ -39,12 +43,23 @@ func (h *LoginHandler) DoSysLogin(ctx iface.IContext) {
 	if old != nil {
 		ctx.Dispatch(&pkt.KickoutNotify{ChannelId: old.ChannelID}, old)
 	}
+	// 登录之前的在线状态，用于判断是否需要通知上线
+	var wasOnline bool
+	if h.presence != nil {
+		if wasOnline, err = h.presence.IsOnline(ctx, session.Account); err != nil {
+			ctx.RespWithError(pkt.Status_SystemException, err)
+			return
+		}
+	}
 	// 添加会话到会话管理
 	err = ctx.Add(&session)
 	if err != nil {
 		ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
+	if h.presence != nil {
+		h.presence.Online(ctx, session.Account, wasOnline)
+	}
 	//通知登录成功
 	resp := &pkt.LoginResp{
 		ChannelId: session.ChannelId,
@@ -64,5 +79,8 @@ func (h *LoginHandler) DoSysLogout(ctx iface.IContext) {
 		ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
+	if h.presence != nil {
+		h.presence.Offline(ctx, ctx.Session().GetAccount())
+	}
 	ctx.Resp(pkt.Status_Success, nil)
 }
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"fmt"
+	"im/iface"
+	"im/logger"
+	"im/wire/presence"
+	"time"
+
+	"github.com/klintcheng/kim/wire/pkt"
+)
+
+// MaxPresenceAccounts 一次查询或订阅的最大账号数
+const MaxPresenceAccounts = 200
+
+// PresenceContext 通知订阅者时需要的会话查询与推送，iface.IContext满足该接口
+type PresenceContext interface {
+	GetLocations(...string) ([]*iface.Location, error)
+	Push(gateway string, channels []string, p *pkt.LogicPkt) error
+}
+
+type presenceContext struct {
+	iface.ISessionStorage
+	iface.Dispatcher
+}
+
+// PresenceAuthorizer 判断subscriber能否订阅accounts的在线状态，不允许时返回错误
+type PresenceAuthorizer interface {
+	Authorize(app, subscriber string, accounts []string) error
+}
+
+// PresenceAuthorizerFunc 函数形式的PresenceAuthorizer
+type PresenceAuthorizerFunc func(app, subscriber string, accounts []string) error
+
+func (f PresenceAuthorizerFunc) Authorize(app, subscriber string, accounts []string) error {
+	return f(app, subscriber, accounts)
+}
+
+// SelfOnly 默认的授权，只允许订阅自己的账号。业务需要按好友关系等授权时通过WithAuthorizer替换
+var SelfOnly = PresenceAuthorizerFunc(func(app, subscriber string, accounts []string) error {
+	for _, account := range accounts {
+		if account != subscriber {
+			return fmt.Errorf("%s is not allowed to subscribe %s", subscriber, account)
+		}
+	}
+	return nil
+})
+
+type PresenceHandler struct {
+	store      iface.IPresenceStorage
+	authorizer PresenceAuthorizer
+}
+
+type PresenceOption func(h *PresenceHandler)
+
+// WithAuthorizer 设置订阅在线状态的授权，默认SelfOnly
+func WithAuthorizer(authorizer PresenceAuthorizer) PresenceOption {
+	return func(h *PresenceHandler) {
+		h.authorizer = authorizer
+	}
+}
+
+func NewPresenceHandler(store iface.IPresenceStorage, opts ...PresenceOption) *PresenceHandler {
+	h := &PresenceHandler{
+		store:      store,
+		authorizer: SelfOnly,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *PresenceHandler) DoQuery(ctx iface.IContext) {
+	var req presence.QueryReq
+	if err := ctx.ReadBody(&req); err != nil {
+		ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if len(req.Accounts) > MaxPresenceAccounts {
+		ctx.RespWithError(pkt.Status_InvalidPacketBody, fmt.Errorf("accounts more than %d", MaxPresenceAccounts))
+		return
+	}
+	h.respStatuses(ctx, req.Accounts)
+}
+
+// DoSubscribe 订阅账号的在线状态变化，并返回这些账号当前的状态
+func (h *PresenceHandler) DoSubscribe(ctx iface.IContext) {
+	var req presence.SubscribeReq
+	if err := ctx.ReadBody(&req); err != nil {
+		ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if len(req.Accounts) > MaxPresenceAccounts {
+		ctx.RespWithError(pkt.Status_InvalidPacketBody, fmt.Errorf("accounts more than %d", MaxPresenceAccounts))
+		return
+	}
+	if err := h.authorizer.Authorize(ctx.Session().GetApp(), ctx.Session().GetAccount(), req.Accounts); err != nil {
+		ctx.RespWithError(pkt.Status_Unauthorized, err)
+		return
+	}
+	if err := h.store.Subscribe(ctx.Session().GetAccount(), req.Accounts...); err != nil {
+		ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	h.respStatuses(ctx, req.Accounts)
+}
+
+func (h *PresenceHandler) DoUnsubscribe(ctx iface.IContext) {
+	var req presence.UnsubscribeReq
+	if err := ctx.ReadBody(&req); err != nil {
+		ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if err := h.store.Unsubscribe(ctx.Session().GetAccount(), req.Accounts...); err != nil {
+		ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	ctx.Resp(pkt.Status_Success, nil)
+}
+
+// respStatuses 返回账号当前的状态，账号数量由调用方检查
+func (h *PresenceHandler) respStatuses(ctx iface.IContext, accounts []string) {
+	lastSeen, err := h.store.GetLastSeen(accounts...)
+	if err != nil {
+		ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	locs, err := ctx.GetLocations(accounts...)
+	if err != nil {
+		ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	online := make(map[string]bool, len(locs))
+	for _, loc := range locs {
+		online[loc.Account] = true
+	}
+	resp := &presence.QueryResp{
+		Statuses: make([]*presence.Status, 0, len(accounts)),
+	}
+	for _, account := range accounts {
+		resp.Statuses = append(resp.Statuses, &presence.Status{
+			Account:  account,
+			Online:   online[account],
+			LastSeen: lastSeen[account],
+		})
+	}
+	_ = ctx.Resp(pkt.Status_Success, resp)
+}
+
+// IsOnline 账号是否有在线的会话，登录之前调用，结果交给Online
+func (h *PresenceHandler) IsOnline(ctx PresenceContext, account string) (bool, error) {
+	locs, err := ctx.GetLocations(account)
+	if err != nil {
+		return false, err
+	}
+	return len(locs) > 0, nil
+}
+
+// Online 会话登录后调用，只有登录之前不在线时才通知订阅者。
+// 同一位置上的重新登录替换了旧会话，登录前后都在线，不会重复通知
+func (h *PresenceHandler) Online(ctx PresenceContext, account string, wasOnline bool) {
+	if wasOnline {
+		return
+	}
+	online, err := h.IsOnline(ctx, account)
+	if err != nil || !online {
+		return
+	}
+	h.notify(ctx, account, true)
+}
+
+// Offline 账号的会话注销后调用，没有其它在线的设备时通知订阅者
+func (h *PresenceHandler) Offline(ctx PresenceContext, account string) {
+	locs, err := ctx.GetLocations(account)
+	if err != nil || len(locs) != 0 {
+		return
+	}
+	h.notify(ctx, account, false)
+}
+
+// Removed 会话过期或者所在的网关被清理之后调用，这些会话没有注销的请求，
+// 通过sessions与dispatcher通知订阅者，同一个账号只通知一次
+func (h *PresenceHandler) Removed(sessions iface.ISessionStorage, dispatcher iface.Dispatcher, accounts []string) {
+	ctx := &presenceContext{sessions, dispatcher}
+	done := make(map[string]struct{}, len(accounts))
+	for _, account := range accounts {
+		if _, ok := done[account]; ok {
+			continue
+		}
+		done[account] = struct{}{}
+		h.Offline(ctx, account)
+	}
+}
+
+func (h *PresenceHandler) notify(ctx PresenceContext, account string, online bool) {
+	log := logger.WithFields(logger.Fields{
+		"Func":    "PresenceNotify",
+		"Account": account,
+		"Online":  online,
+	})
+	now := time.Now()
+	if err := h.store.SetLastSeen(account, now); err != nil {
+		log.Warn(err)
+	}
+	subscribers, err := h.store.Subscribers(account)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+	locs, err := ctx.GetLocations(subscribers...)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if len(locs) == 0 {
+		return
+	}
+
+	status := &presence.Status{
+		Account:  account,
+		Online:   online,
+		LastSeen: now.UnixNano() / int64(time.Millisecond),
+	}
+	group := make(map[string][]string)
+	for _, loc := range locs {
+		group[loc.GateId] = append(group[loc.GateId], loc.ChannelID)
+	}
+	// Push会在包中添加目标channels，每个网关使用单独的包
+	for gateway, channels := range group {
+		packet := pkt.New(presence.CommandNotify)
+		packet.Flag = pkt.Flag_Push
+		packet.WriteBody(status)
+		if err := ctx.Push(gateway, channels, packet); err != nil {
+			log.Warn(err)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"fmt"
+	"im/core"
+	"im/storage"
+	"im/wire/presence"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire"
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+type pushed struct {
+	gateway  string
+	channels []string
+	packet   *pkt.LogicPkt
+}
+
+type testDispatcher struct {
+	packets []pushed
+}
+
+func (d *testDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	d.packets = append(d.packets, pushed{gateway, channels, p})
+	return nil
+}
+
+func (d *testDispatcher) find(command string, flag pkt.Flag) *pushed {
+	for i := range d.packets {
+		if d.packets[i].packet.Command == command && d.packets[i].packet.Flag == flag {
+			return &d.packets[i]
+		}
+	}
+	return nil
+}
+
+// friends 只允许订阅好友的在线状态
+func friends(relations map[string][]string) PresenceAuthorizer {
+	return PresenceAuthorizerFunc(func(app, subscriber string, accounts []string) error {
+		for _, account := range accounts {
+			found := false
+			for _, friend := range relations[subscriber] {
+				if friend == account {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s is not a friend of %s", account, subscriber)
+			}
+		}
+		return nil
+	})
+}
+
+func TestPresence(t *testing.T) {
+	cache := storage.NewMemoryStorage()
+	presenceHandler := NewPresenceHandler(storage.NewMemoryPresence(), WithAuthorizer(friends(map[string][]string{"alice": {"bob"}})))
+	loginHandler := NewLoginHandler(presenceHandler)
+
+	r := core.NewRouter()
+	r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
+	r.Handle(wire.CommandLoginSignOut, loginHandler.DoSysLogout)
+	r.Handle(presence.CommandSubscribe, presenceHandler.DoSubscribe)
+	r.Handle(presence.CommandQuery, presenceHandler.DoQuery)
+
+	alice := &pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "alice"}
+	_ = cache.Add(alice)
+	bob := &pkt.Session{ChannelId: "ch2", GateId: "gate2", Account: "bob"}
+
+	// alice订阅bob，此时bob不在线
+	d := &testDispatcher{}
+	req := pkt.New(presence.CommandSubscribe, pkt.WithChannel(alice.ChannelId))
+	req.WriteBody(&presence.SubscribeReq{Accounts: []string{"bob"}})
+	assert.Nil(t, r.Serve(req, d, cache, alice))
+	var resp presence.QueryResp
+	assert.Nil(t, d.find(presence.CommandSubscribe, pkt.Flag_Response).packet.ReadBody(&resp))
+	assert.False(t, resp.Statuses[0].Online)
+
+	// bob登录后通知alice
+	d = &testDispatcher{}
+	login := pkt.New(wire.CommandLoginSignIn, pkt.WithChannel(bob.ChannelId))
+	login.WriteBody(bob)
+	assert.Nil(t, r.Serve(login, d, cache, bob))
+	notify := d.find(presence.CommandNotify, pkt.Flag_Push)
+	assert.NotNil(t, notify)
+	assert.Equal(t, "gate1", notify.gateway)
+	assert.Equal(t, []string{"ch1"}, notify.channels)
+	var status presence.Status
+	assert.Nil(t, notify.packet.ReadBody(&status))
+	assert.True(t, status.Online)
+	assert.NotZero(t, status.LastSeen)
+
+	// bob注销
+	d = &testDispatcher{}
+	assert.Nil(t, r.Serve(pkt.New(wire.CommandLoginSignOut, pkt.WithChannel(bob.ChannelId)), d, cache, bob))
+	notify = d.find(presence.CommandNotify, pkt.Flag_Push)
+	assert.NotNil(t, notify)
+	assert.Nil(t, notify.packet.ReadBody(&status))
+	assert.False(t, status.Online)
+
+	d = &testDispatcher{}
+	query := pkt.New(presence.CommandQuery, pkt.WithChannel(alice.ChannelId))
+	query.WriteBody(&presence.QueryReq{Accounts: []string{"bob", "alice"}})
+	assert.Nil(t, r.Serve(query, d, cache, alice))
+	assert.Nil(t, d.find(presence.CommandQuery, pkt.Flag_Response).packet.ReadBody(&resp))
+	assert.False(t, resp.Statuses[0].Online)
+	assert.NotZero(t, resp.Statuses[0].LastSeen)
+	assert.True(t, resp.Statuses[1].Online)
+}
+
+func TestPresenceRemoved(t *testing.T) {
+	cache := storage.NewMemoryStorage(storage.WithDevicePolicy(storage.DeviceUnlimited))
+	presenceHandler := NewPresenceHandler(storage.NewMemoryPresence())
+
+	alice := &pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "alice"}
+	_ = cache.Add(alice)
+	_ = cache.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate2", Account: "bob"})
+	_ = cache.Add(&pkt.Session{ChannelId: "ch3", GateId: "gate2", Account: "bob"})
+	_ = cache.Add(&pkt.Session{ChannelId: "ch4", GateId: "gate3", Account: "carol"})
+	assert.Nil(t, presenceHandler.store.Subscribe("alice", "bob", "carol"))
+
+	// gate2被清理，bob的两个会话只通知一次
+	accounts, err := cache.PurgeGate("gate2", time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Len(t, accounts, 2)
+	d := &testDispatcher{}
+	presenceHandler.Removed(cache, d, accounts)
+	assert.Len(t, d.packets, 1)
+	assert.Equal(t, "gate1", d.packets[0].gateway)
+	var status presence.Status
+	assert.Nil(t, d.packets[0].packet.ReadBody(&status))
+	assert.Equal(t, "bob", status.Account)
+	assert.False(t, status.Online)
+
+	// carol仍然在线，不通知
+	d = &testDispatcher{}
+	presenceHandler.Removed(cache, d, []string{"carol"})
+	assert.Empty(t, d.packets)
+
+	// 批量查询多个账号的状态
+	r := core.NewRouter()
+	r.Handle(presence.CommandQuery, presenceHandler.DoQuery)
+	query := pkt.New(presence.CommandQuery, pkt.WithChannel(alice.ChannelId))
+	query.WriteBody(&presence.QueryReq{Accounts: []string{"bob", "carol", "alice"}})
+	assert.Nil(t, r.Serve(query, d, cache, alice))
+	var resp presence.QueryResp
+	assert.Nil(t, d.find(presence.CommandQuery, pkt.Flag_Response).packet.ReadBody(&resp))
+	assert.False(t, resp.Statuses[0].Online)
+	assert.NotZero(t, resp.Statuses[0].LastSeen)
+	assert.True(t, resp.Statuses[1].Online)
+	assert.True(t, resp.Statuses[2].Online)
+}
+
+func TestPresenceMaxAccounts(t *testing.T) {
+	cache := storage.NewMemoryStorage()
+	presenceHandler := NewPresenceHandler(storage.NewMemoryPresence())
+	r := core.NewRouter()
+	r.Handle(presence.CommandSubscribe, presenceHandler.DoSubscribe)
+
+	alice := &pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "alice"}
+	accounts := make([]string, MaxPresenceAccounts+1)
+	for i := range accounts {
+		accounts[i] = fmt.Sprintf("user%d", i)
+	}
+	d := &testDispatcher{}
+	req := pkt.New(presence.CommandSubscribe, pkt.WithChannel(alice.ChannelId))
+	req.WriteBody(&presence.SubscribeReq{Accounts: accounts})
+	assert.Nil(t, r.Serve(req, d, cache, alice))
+	assert.Equal(t, pkt.Status_InvalidPacketBody, d.find(presence.CommandSubscribe, pkt.Flag_Response).packet.Status)
+	// 超过数量时不会订阅
+	subscribers, err := presenceHandler.store.Subscribers("user0")
+	assert.Nil(t, err)
+	assert.Empty(t, subscribers)
+}
+
+func TestPresenceSubscribeAuthorize(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []PresenceOption
+		accounts []string
+		want     pkt.Status
+	}{
+		{"self", nil, []string{"alice"}, pkt.Status_Success},
+		{"stranger", nil, []string{"bob"}, pkt.Status_Unauthorized},
+		{"friend", []PresenceOption{WithAuthorizer(friends(map[string][]string{"alice": {"bob"}}))}, []string{"bob"}, pkt.Status_Success},
+		{"friend and stranger", []PresenceOption{WithAuthorizer(friends(map[string][]string{"alice": {"bob"}}))}, []string{"bob", "carol"}, pkt.Status_Unauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := storage.NewMemoryStorage()
+			presenceHandler := NewPresenceHandler(storage.NewMemoryPresence(), tt.opts...)
+			r := core.NewRouter()
+			r.Handle(presence.CommandSubscribe, presenceHandler.DoSubscribe)
+
+			alice := &pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "alice"}
+			d := &testDispatcher{}
+			req := pkt.New(presence.CommandSubscribe, pkt.WithChannel(alice.ChannelId))
+			req.WriteBody(&presence.SubscribeReq{Accounts: tt.accounts})
+			assert.Nil(t, r.Serve(req, d, cache, alice))
+			assert.Equal(t, tt.want, d.find(presence.CommandSubscribe, pkt.Flag_Response).packet.Status)
+
+			// 拒绝时不会订阅任何账号
+			subscribers, err := presenceHandler.store.Subscribers(tt.accounts[0])
+			assert.Nil(t, err)
+			if tt.want == pkt.Status_Success {
+				assert.Equal(t, []string{"alice"}, subscribers)
+			} else {
+				assert.Empty(t, subscribers)
+			}
+		})
+	}
+}
+
+// 同一位置上的重新登录替换旧会话，不会重复通知上线；另一种设备登录时同样不通知
+func TestPresenceRelogin(t *testing.T) {
+	cache := storage.NewMemoryStorage(storage.WithDevicePolicy(storage.DevicePerType))
+	presenceHandler := NewPresenceHandler(storage.NewMemoryPresence(), WithAuthorizer(friends(map[string][]string{"alice": {"bob"}})))
+	loginHandler := NewLoginHandler(presenceHandler)
+	r := core.NewRouter()
+	r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
+
+	_ = cache.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "alice"})
+	assert.Nil(t, presenceHandler.store.Subscribe("alice", "bob"))
+
+	tests := []struct {
+		name    string
+		session *pkt.Session
+		notify  bool
+	}{
+		{"first login", &pkt.Session{ChannelId: "ch2", GateId: "gate2", Account: "bob", Device: "iphone"}, true},
+		{"same device", &pkt.Session{ChannelId: "ch3", GateId: "gate2", Account: "bob", Device: "iphone"}, false},
+		{"another device", &pkt.Session{ChannelId: "ch4", GateId: "gate2", Account: "bob", Device: "ipad"}, false},
+	}
+	for _, tt := range tests {
+		d := &testDispatcher{}
+		login := pkt.New(wire.CommandLoginSignIn, pkt.WithChannel(tt.session.ChannelId))
+		login.WriteBody(tt.session)
+		assert.Nil(t, r.Serve(login, d, cache, tt.session))
+		assert.Equal(t, pkt.Status_Success, d.find(wire.CommandLoginSignIn, pkt.Flag_Response).packet.Status, tt.name)
+		assert.Equal(t, tt.notify, d.find(presence.CommandNotify, pkt.Flag_Push) != nil, tt.name)
+	}
+}
@@ -0,0 +1,126 @@
+package serv
+
+import (
+	"im/iface"
+	"im/naming"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultGatewayGrace 网关从注册中心消失后等待的时间，超时后清理它的会话
+const DefaultGatewayGrace = time.Second * 30
+
+// RemovedFunc 会话没有经过注销就被删除之后调用，accounts是这些会话的账号
+type RemovedFunc func(accounts []string)
+
+type gateway struct {
+	name      string
+	startTime int64
+	purge     *time.Timer
+}
+
+// GatewayWatcher 观察网关的注册信息，网关崩溃或者以同一个ServiceID重启时清理它遗留的会话
+type GatewayWatcher struct {
+	sync.Mutex
+	cache    iface.ISessionStorage
+	grace    time.Duration
+	removed  RemovedFunc
+	gateways map[string]*gateway //ServiceID -> gateway
+}
+
+// NewGatewayWatcher grace为0时使用DefaultGatewayGrace，removed可以为nil
+func NewGatewayWatcher(cache iface.ISessionStorage, grace time.Duration, removed RemovedFunc) *GatewayWatcher {
+	if grace <= 0 {
+		grace = DefaultGatewayGrace
+	}
+	return &GatewayWatcher{
+		cache:    cache,
+		grace:    grace,
+		removed:  removed,
+		gateways: make(map[string]*gateway),
+	}
+}
+
+// Watch 订阅网关服务的变化
+func (w *GatewayWatcher) Watch(ns iface.Naming, names ...string) error {
+	for _, name := range names {
+		services, err := ns.Find(name)
+		if err != nil {
+			return err
+		}
+		w.update(name, services)
+		name := name
+		err = ns.Subscribe(name, func(services []iface.ServiceRegistration) {
+			w.update(name, services)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *GatewayWatcher) update(name string, services []iface.ServiceRegistration) {
+	w.Lock()
+	defer w.Unlock()
+
+	alive := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		id := service.ServiceID()
+		alive[id] = struct{}{}
+		startTime, _ := strconv.ParseInt(service.GetMeta()[naming.KeyStartTime], 10, 64)
+
+		gw, ok := w.gateways[id]
+		if !ok {
+			w.gateways[id] = &gateway{name: name, startTime: startTime}
+			continue
+		}
+		if gw.purge != nil {
+			gw.purge.Stop()
+			gw.purge = nil
+		}
+		// 同一个ServiceID重启过，重启之前的会话都已经失效
+		if startTime > gw.startTime && gw.startTime != 0 {
+			log.Infof("gateway %s restarted", id)
+			go w.purge(id, time.Unix(0, startTime*int64(time.Millisecond)))
+		}
+		gw.startTime = startTime
+	}
+
+	for id, gw := range w.gateways {
+		if _, ok := alive[id]; ok || gw.name != name || gw.purge != nil {
+			continue
+		}
+		// 网关可能只是短暂的健康检查失败，等待grace之后仍然没有恢复时再清理
+		id, gw := id, gw
+		log.Infof("gateway %s is gone, purge sessions after %v", id, w.grace)
+		var timer *time.Timer
+		timer = time.AfterFunc(w.grace, func() {
+			w.Lock()
+			// 期间网关已经恢复
+			if gw.purge != timer {
+				w.Unlock()
+				return
+			}
+			delete(w.gateways, id)
+			w.Unlock()
+			w.purge(id, time.Now())
+		})
+		gw.purge = timer
+	}
+}
+
+func (w *GatewayWatcher) purge(gateId string, before time.Time) {
+	accounts, err := w.cache.PurgeGate(gateId, before)
+	if err != nil {
+		log.Warnf("purge sessions of gateway %s: %v", gateId, err)
+	}
+	if len(accounts) == 0 {
+		return
+	}
+	log.Infof("purged %d sessions of gateway %s", len(accounts), gateId)
+	if w.removed != nil {
+		w.removed(accounts)
+	}
+}
@@ -0,0 +1,58 @@
+package serv
+
+import (
+	"im/iface"
+	"im/naming"
+	"im/naming/memory"
+	"im/storage"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func gatewayService(id string, start time.Time) *naming.DefaultService {
+	return &naming.DefaultService{
+		Id:   id,
+		Name: "tgateway",
+		Meta: map[string]string{naming.KeyStartTime: strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10)},
+	}
+}
+
+func TestGatewayWatcher(t *testing.T) {
+	ns := memory.NewNaming()
+	cache := storage.NewMemoryStorage()
+
+	start := time.Now().Add(-time.Minute)
+	assert.Nil(t, ns.Register(gatewayService("gate1", start)))
+	assert.Nil(t, ns.Register(gatewayService("gate2", start)))
+	_ = cache.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1"})
+	_ = cache.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate2", Account: "test2"})
+
+	removed := make(chan []string, 2)
+	w := NewGatewayWatcher(cache, time.Millisecond*50, func(accounts []string) {
+		removed <- accounts
+	})
+	assert.Nil(t, w.Watch(ns, "tgateway"))
+
+	// gate1以同一个ServiceID重启
+	time.Sleep(time.Millisecond * 5)
+	assert.Nil(t, ns.Register(gatewayService("gate1", time.Now())))
+	assert.Eventually(t, func() bool {
+		_, err := cache.Get("ch1")
+		return err == iface.ErrSessionNil
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, []string{"test1"}, <-removed)
+
+	// gate2崩溃
+	assert.Nil(t, ns.Deregister("gate2"))
+	_, err := cache.Get("ch2")
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := cache.Get("ch2")
+		return err == iface.ErrSessionNil
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, []string{"test2"}, <-removed)
+}
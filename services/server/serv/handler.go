@@ -6,6 +6,7 @@ import (
 	"im/core"
 	"im/iface"
 	"im/logger"
+	"im/tcp"
 	"strings"
 	"time"
 
@@ -22,7 +23,7 @@ var log = logger.WithFields(logger.Fields{
 type ServHandler struct {
 	r          *core.Router
 	cache      iface.ISessionStorage
-	dispatcher *ServerDispatcher
+	dispatcher iface.Dispatcher
 }
 
 func NewServHandler(r *core.Router, cache iface.ISessionStorage) *ServHandler {
@@ -33,6 +34,11 @@ func NewServHandler(r *core.Router, cache iface.ISessionStorage) *ServHandler {
 	}
 }
 
+// SetDispatcher 替换下行消息的发送方式，默认通过container推送到网关
+func (h *ServHandler) SetDispatcher(dispatcher iface.Dispatcher) {
+	h.dispatcher = dispatcher
+}
+
 // 握手
 func (h *ServHandler) Accept(conn iface.IConn, timeout time.Duration) (string, error) {
 	log.Infoln("enter")
@@ -44,6 +50,12 @@ func (h *ServHandler) Accept(conn iface.IConn, timeout time.Duration) (string, e
 	var req pkt.InnerHandshakeReq
 	proto.Unmarshal(frame.GetPayload(), &req)
 	log.Info("Accept -- ", req.ServiceId)
+	// 使用带版本帧格式的网关等待回复，以确定协商后的能力
+	if tc, ok := conn.(*tcp.TcpConn); ok {
+		if err = tc.AckHandshake(); err != nil {
+			return "", err
+		}
+	}
 	return req.ServiceId, nil
 }
 
@@ -56,6 +68,14 @@ func (h *ServHandler) Receive(ag iface.IAgent, payload []byte) {
 		return
 	}
 
+	// 网关的保活通知只刷新会话，不需要路由与响应
+	if packet.Command == iface.CommandSessionKeepAlive {
+		if err := h.cache.Refresh(packet.ChannelId); err != nil {
+			log.Debugf("refresh session %s: %v", packet.ChannelId, err)
+		}
+		return
+	}
+
 	var session *pkt.Session
 	if packet.Command == wire.CommandLoginSignIn {
 		server, _ := packet.GetMeta(wire.MetaDestServer)
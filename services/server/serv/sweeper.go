@@ -0,0 +1,69 @@
+package serv
+
+import (
+	"im/iface"
+	"sync"
+	"time"
+)
+
+// DefaultSweepInterval 清理过期会话的默认间隔
+const DefaultSweepInterval = time.Minute
+
+// SessionSweeper 定时清理过期的会话。会话过期时存储不会通知，
+// 由它找出这些会话交给removed，用于通知在线状态的订阅者
+type SessionSweeper struct {
+	cache    iface.ISessionStorage
+	interval time.Duration
+	removed  RemovedFunc
+	quit     chan struct{}
+	once     sync.Once
+}
+
+// NewSessionSweeper interval为0时使用DefaultSweepInterval，removed可以为nil
+func NewSessionSweeper(cache iface.ISessionStorage, interval time.Duration, removed RemovedFunc) *SessionSweeper {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	return &SessionSweeper{
+		cache:    cache,
+		interval: interval,
+		removed:  removed,
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start 在后台定时清理，直到Stop
+func (s *SessionSweeper) Start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.quit:
+				return
+			}
+			s.sweep(time.Now())
+		}
+	}()
+}
+
+func (s *SessionSweeper) Stop() {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+}
+
+func (s *SessionSweeper) sweep(now time.Time) {
+	accounts, err := s.cache.RemoveExpired(now)
+	if err != nil {
+		log.Warnf("remove expired sessions: %v", err)
+	}
+	if len(accounts) == 0 {
+		return
+	}
+	log.Debugf("removed %d expired sessions", len(accounts))
+	if s.removed != nil {
+		s.removed(accounts)
+	}
+}
@@ -0,0 +1,26 @@
+package serv
+
+import (
+	"im/storage"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionSweeper(t *testing.T) {
+	cache := storage.NewMemoryStorage(storage.WithExpired(time.Second))
+	_ = cache.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1"})
+
+	var removed []string
+	s := NewSessionSweeper(cache, 0, func(accounts []string) {
+		removed = append(removed, accounts...)
+	})
+	assert.Equal(t, DefaultSweepInterval, s.interval)
+
+	s.sweep(time.Now())
+	assert.Empty(t, removed)
+	s.sweep(time.Now().Add(time.Second * 2))
+	assert.Equal(t, []string{"test1"}, removed)
+}
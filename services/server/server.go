@@ -8,12 +8,19 @@ import (
 	"im/logger"
 	"im/naming"
 	"im/naming/consul"
+	"im/naming/file"
+	"im/naming/memory"
+	redisnaming "im/naming/redis"
 	"im/services/server/conf"
 	"im/services/server/handler"
 	"im/services/server/serv"
 	"im/storage"
 	"im/tcp"
+	"im/wire/presence"
+	"os"
+	"strconv"
 
+	"github.com/go-redis/redis/v7"
 	"github.com/klintcheng/kim/wire"
 	"github.com/spf13/cobra"
 )
@@ -45,33 +52,72 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	logger.Init(logger.Settings{
 		Level: "trace",
 	})
+	policy, err := storage.ParseDevicePolicy(config.DevicePolicy)
+	if err != nil {
+		return err
+	}
+	storageOpts := []storage.Option{storage.WithDevicePolicy(policy)}
+	if config.SessionExpired > 0 {
+		storageOpts = append(storageOpts, storage.WithExpired(config.SessionExpired))
+	}
+	//初始化redis，login与chat通过它共享会话
+	rdb, err := conf.InitRedis(config.RedisAddrs, "")
+	if err != nil {
+		return err
+	}
+	//实例化 session storage
+	cache := storage.NewRedisStoreage(rdb, storageOpts...)
+	presenceStore := storage.NewRedisPresence(rdb)
 	//实例化路由
 	r := core.NewRouter()
-	//实例化 登录方法
-	loginHandler := handler.NewLoginHandler()
+	//实例化 登录方法与在线状态，订阅在线状态默认只允许订阅自己，按好友关系授权时通过handler.WithAuthorizer设置
+	presenceHandler := handler.NewPresenceHandler(presenceStore)
+	loginHandler := handler.NewLoginHandler(presenceHandler)
 	//注册路由
 	r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
 	r.Handle(wire.CommandLoginSignOut, loginHandler.DoSysLogout)
-	//初始化redis
-	rdb, err := conf.InitRedis(config.RedisAddrs, "")
-	if err != nil {
-		return err
-	}
-	//实例化 session storage 基于redis实现
-	cache := storage.NewRedisStoreage(rdb)
+	r.Handle(presence.CommandQuery, presenceHandler.DoQuery)
+	r.Handle(presence.CommandSubscribe, presenceHandler.DoSubscribe)
+	r.Handle(presence.CommandUnsubscribe, presenceHandler.DoUnsubscribe)
 	//实例化通信层handler
 	servhandler := serv.NewServHandler(r, cache)
 	//consul服务配置
 	service := &naming.DefaultService{
-		Id:       config.ServiceID,
-		Name:     opts.serviceName,
-		Address:  config.PublicAddress,
-		Port:     config.PublicPort,
-		Protocol: string(wire.ProtocolTCP),
-		Tags:     config.Tags,
+		Id:        config.ServiceID,
+		Name:      opts.serviceName,
+		Address:   config.PublicAddress,
+		Port:      config.PublicPort,
+		Namespace: config.Namespace,
+		Protocol:  string(wire.ProtocolTCP),
+		Tags:      config.Tags,
+		// 网关据此使用带版本的帧格式，没有这一项的逻辑服务只支持旧格式
+		Meta: map[string]string{naming.KeyFrameVersion: strconv.Itoa(int(tcp.ProtocolVersion))},
+	}
+	// 与网关部署在同一主机时可以监听unix socket
+	if network, address := tcp.ParseAddress(config.Listen); network == "unix" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		service.Protocol = naming.ProtocolUnix
+		service.Address = address
+		service.Port = 0
+		// 其它主机上的网关通过主机名过滤掉这个服务
+		service.Meta[naming.KeyHost] = host
 	}
 	//构造通信server
-	srv := tcp.NewServer(config.Listen, service)
+	var tcpOpts []tcp.Option
+	if config.MaxPayload > 0 {
+		tcpOpts = append(tcpOpts, tcp.WithMaxPayload(config.MaxPayload))
+	}
+	if config.TLSEnable {
+		tlsConfig, err := core.NewServerTLSConfig(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			return err
+		}
+		tcpOpts = append(tcpOpts, tcp.WithTLSConfig(tlsConfig))
+	}
+	srv := tcp.NewServer(config.Listen, service, tcpOpts...)
 	srv.SetReadWait(iface.DefaultReadWait)
 	srv.SetAcceptor(servhandler)
 	srv.SetMessageListener(servhandler)
@@ -81,10 +127,43 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		return err
 	}
 
-	ns, err := consul.NewNaming(config.ConsulURL)
+	var ns iface.Naming
+	if config.NamingFile != "" {
+		ns, err = file.NewNaming(config.NamingFile, 0, memory.WithNamespace(config.Namespace))
+	} else if config.NamingRedis != "" {
+		var cli *redis.Client
+		if cli, err = conf.InitRedis(config.NamingRedis, ""); err == nil {
+			ns = redisnaming.NewNaming(cli, 0, redisnaming.WithNamespace(config.Namespace))
+		}
+	} else {
+		consulOpts := []consul.Option{consul.WithNamespace(config.Namespace)}
+		if config.HealthTTL > 0 {
+			consulOpts = append(consulOpts, consul.WithTTL(config.HealthTTL))
+		}
+		if config.DeregisterCriticalAfter > 0 {
+			consulOpts = append(consulOpts, consul.WithDeregisterCriticalAfter(config.DeregisterCriticalAfter))
+		}
+		ns, err = consul.NewNaming(config.ConsulURL, consulOpts...)
+	}
 	if err != nil {
 		return err
 	}
 	container.SetServiceNaming(ns)
+
+	gateways := config.Gateways
+	if len(gateways) == 0 {
+		gateways = []string{wire.SNWGateway, wire.SNTGateway}
+	}
+	// 会话过期或者网关被清理时没有注销请求，在这里通知在线状态的订阅者
+	dispatcher := &serv.ServerDispatcher{}
+	removed := func(accounts []string) {
+		presenceHandler.Removed(cache, dispatcher, accounts)
+	}
+	if err := serv.NewGatewayWatcher(cache, config.GatewayGrace, removed).Watch(ns, gateways...); err != nil {
+		return err
+	}
+	sweeper := serv.NewSessionSweeper(cache, config.SessionSweep, removed)
+	sweeper.Start()
+	defer sweeper.Stop()
 	return container.Start()
 }
@@ -0,0 +1,193 @@
+package sse
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"im/core"
+	"im/iface"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConnClosed  = errors.New("sse: connection closed")
+	ErrReadTimeout = errors.New("sse: read timeout")
+	ErrInboxFull   = errors.New("sse: inbox is full")
+)
+
+// 下行事件名与OpCode的对应关系
+var eventNames = map[iface.OpCode]string{
+	iface.OpText:   "text",
+	iface.OpBinary: "binary",
+	iface.OpClose:  "close",
+	iface.OpPing:   "ping",
+	iface.OpPong:   "pong",
+}
+
+// ParseOpCode 解析上行请求中的op参数，为空时是OpBinary
+func ParseOpCode(op string) (iface.OpCode, error) {
+	if op == "" {
+		return iface.OpBinary, nil
+	}
+	for code, name := range eventNames {
+		if name == op {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown op: %s", op)
+}
+
+type addr string
+
+func (a addr) Network() string { return "sse" }
+func (a addr) String() string  { return string(a) }
+
+// Conn 下行是一个SSE长连接，上行是同一个会话下的POST请求
+type Conn struct {
+	sync.Mutex
+	sid     string
+	w       http.ResponseWriter
+	flusher http.Flusher
+	local   net.Addr
+	remote  net.Addr
+	inbox   chan *Frame
+	closed  *core.Event
+
+	rmu          sync.Mutex
+	readDeadline time.Time
+}
+
+func newConn(sid string, w http.ResponseWriter, r *http.Request, inbox int) (*Conn, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("sse: streaming is not supported")
+	}
+	return &Conn{
+		sid:     sid,
+		w:       w,
+		flusher: flusher,
+		local:   addr(r.Host),
+		remote:  addr(r.RemoteAddr),
+		inbox:   make(chan *Frame, inbox),
+		closed:  core.NewEvent(),
+	}, nil
+}
+
+// ReadFrame 读取客户端POST上来的消息帧
+func (c *Conn) ReadFrame() (iface.IFrame, error) {
+	c.rmu.Lock()
+	deadline := c.readDeadline
+	c.rmu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case frame := <-c.inbox:
+		return frame, nil
+	case <-c.closed.Done():
+		return nil, ErrConnClosed
+	case <-timeout:
+		return nil, ErrReadTimeout
+	}
+}
+
+// WriteFrame 以SSE事件下发，payload使用base64编码
+func (c *Conn) WriteFrame(code iface.OpCode, payload []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed.HasFired() {
+		return ErrConnClosed
+	}
+	event, ok := eventNames[code]
+	if !ok {
+		return fmt.Errorf("unsupported opcode: %d", code)
+	}
+	_, err := fmt.Fprintf(c.w, "event: %s\ndata: %s\n\n", event, base64.StdEncoding.EncodeToString(payload))
+	if err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+func (c *Conn) writeSession() error {
+	c.Lock()
+	defer c.Unlock()
+	_, err := fmt.Fprintf(c.w, "event: session\ndata: %s\n\n", c.sid)
+	if err != nil {
+		return err
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// deliver 把上行的消息帧放入收件箱
+func (c *Conn) deliver(frame *Frame) error {
+	select {
+	case <-c.closed.Done():
+		return ErrConnClosed
+	default:
+	}
+	select {
+	case c.inbox <- frame:
+		return nil
+	default:
+		return ErrInboxFull
+	}
+}
+
+func (c *Conn) Flush() error {
+	return nil
+}
+
+// Read 不支持按字节读取，使用ReadFrame
+func (c *Conn) Read(b []byte) (int, error) {
+	return 0, errors.New("sse: use ReadFrame instead")
+}
+
+// Write 以OpBinary下发
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.WriteFrame(iface.OpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 等待正在进行的写入结束，之后不能再使用ResponseWriter
+func (c *Conn) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed.Fire()
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.rmu.Lock()
+	c.readDeadline = t
+	c.rmu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline http.ResponseWriter不支持写超时
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
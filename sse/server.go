@@ -0,0 +1,409 @@
+package sse
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"im/core"
+	"im/iface"
+	"im/logger"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+)
+
+type ServerOptions struct {
+	loginwait time.Duration //登陆超时
+	readwait  time.Duration //读超时
+	writewait time.Duration //写超时
+	path      string        //SSE路径
+	inbox     int           //每个会话缓存的上行消息数
+	maxBody   int64         //上行消息最大长度
+	tlsConfig *tls.Config   //不为空时以https提供服务
+	origins   []string      //允许跨域访问的Origin，为空时不校验
+	limiter   *core.ConnLimiter
+}
+
+type Option func(opts *ServerOptions)
+
+// WithPath 设置SSE的路径，默认为 /sse
+func WithPath(path string) Option {
+	return func(opts *ServerOptions) {
+		opts.path = path
+	}
+}
+
+// WithTLSConfig 开启tls监听
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(opts *ServerOptions) {
+		opts.tlsConfig = cfg
+	}
+}
+
+// WithAllowedOrigins 设置允许的Origin，* 表示不限制
+func WithAllowedOrigins(origins ...string) Option {
+	return func(opts *ServerOptions) {
+		opts.origins = origins
+	}
+}
+
+// WithMaxPayload 设置上行消息的最大长度
+func WithMaxPayload(size int) Option {
+	return func(opts *ServerOptions) {
+		opts.maxBody = int64(size)
+	}
+}
+
+// WithConnLimiter 设置连接数限制，超过限制的会话在建立之前被拒绝
+func WithConnLimiter(limiter *core.ConnLimiter) Option {
+	return func(opts *ServerOptions) {
+		opts.limiter = limiter
+	}
+}
+
+// Server 供无法使用websocket的客户端使用的http传输层
+//
+// 请求:
+//
+//	GET  {path}                建立SSE长连接，第一个事件为 session，data为会话id
+//	POST {path}/send?sid=&op=  在会话上发送一个消息帧，body为payload，op默认为binary
+//
+// 下行事件的event为opcode名称，data为base64编码的payload
+type Server struct {
+	listen string
+	iface.ServiceRegistration
+	ChannelMap      iface.IChannelMap
+	Acceptor        iface.IAcceptor
+	MessageListener iface.IMessageListener
+	Statelistener   iface.IStatelistener
+	once            sync.Once
+	options         ServerOptions
+	sessions        sync.Map
+	quit            iface.IEvent
+	lmu             sync.Mutex //保护srv，Shutdown可能在Start开始监听之前调用
+	srv             *http.Server
+}
+
+// NewServer NewServer
+func NewServer(listen string, service iface.ServiceRegistration, opts ...Option) iface.IServer {
+	srv := &Server{
+		listen:              listen,
+		ServiceRegistration: service,
+		quit:                core.NewEvent(),
+		options: ServerOptions{
+			loginwait: iface.DefaultLoginWait,
+			readwait:  iface.DefaultReadWait,
+			writewait: time.Second * 10,
+			path:      "/sse",
+			inbox:     16,
+			maxBody:   iface.DefaultMaxPayload,
+		},
+	}
+	for _, opt := range opts {
+		opt(&srv.options)
+	}
+	if srv.options.limiter == nil {
+		srv.options.limiter = core.NewConnLimiter("sse", core.ConnLimits{})
+	}
+	return srv
+}
+
+// ConnStats 返回当前的连接计数
+func (s *Server) ConnStats() core.ConnStats {
+	return s.options.limiter.Stats()
+}
+
+func (s *Server) Start() error {
+	log := logger.WithFields(logger.Fields{
+		"module": "sse.server",
+		"listen": s.listen,
+		"id":     s.ServiceID(),
+	})
+	mux, err := s.handler()
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	if s.options.tlsConfig != nil {
+		lis = tls.NewListener(lis, s.options.tlsConfig)
+	}
+	s.lmu.Lock()
+	if s.quit.HasFired() {
+		s.lmu.Unlock()
+		_ = lis.Close()
+		return nil
+	}
+	s.srv = &http.Server{Handler: mux}
+	s.lmu.Unlock()
+	log.Infof("sse server started on %s", s.listen)
+	err = s.srv.Serve(lis)
+	// Shutdown关闭了监听
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// handler 返回处理SSE与上行请求的http.Handler
+func (s *Server) handler() (http.Handler, error) {
+	if s.Acceptor == nil {
+		s.Acceptor = new(defaultAcceptor)
+	}
+	if s.Statelistener == nil {
+		return nil, fmt.Errorf("StateListener is nil")
+	}
+	if s.ChannelMap == nil {
+		s.ChannelMap = core.NewChannels(100)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.options.path, s.cors(s.handleEvents))
+	mux.HandleFunc(s.options.path+"/send", s.cors(s.handleSend))
+	return mux, nil
+}
+
+// cors 校验Origin并设置跨域响应头，浏览器对上行的POST会先发送OPTIONS预检请求
+func (s *Server) cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			if !checkOrigin(origin, s.options.origins) {
+				resp(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkOrigin 与websocket相同，allowed为空时不校验
+func checkOrigin(origin string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEvents 建立会话，在请求的生命周期内完成鉴权与读循环
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	log := logger.WithFields(logger.Fields{
+		"module": "sse.server",
+		"id":     s.ServiceID(),
+	})
+	if r.Method != http.MethodGet {
+		resp(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	ip := remoteIP(r)
+	if err := s.options.limiter.Acquire(ip); err != nil {
+		status := http.StatusServiceUnavailable
+		if err == core.ErrTooManyConnsPerIP {
+			status = http.StatusTooManyRequests
+		}
+		resp(w, status, err.Error())
+		return
+	}
+	defer s.options.limiter.Release(ip)
+	handshaking := true
+	defer func() {
+		if handshaking {
+			s.options.limiter.HandshakeDone()
+		}
+	}()
+
+	sid := ksuid.New().String()
+	conn, err := newConn(sid, w, r, s.options.inbox)
+	if err != nil {
+		resp(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	s.sessions.Store(sid, conn)
+	defer s.sessions.Delete(sid)
+	defer conn.Close()
+
+	// 客户端断开时关闭会话
+	go func() {
+		select {
+		case <-r.Context().Done():
+			conn.Close()
+		case <-conn.closed.Done():
+		}
+	}()
+
+	if err = conn.writeSession(); err != nil {
+		return
+	}
+
+	//鉴权
+	id, err := s.Acceptor.Accept(conn, s.options.loginwait)
+	handshaking = false
+	s.options.limiter.HandshakeDone()
+	if err != nil {
+		_ = conn.WriteFrame(iface.OpClose, []byte(err.Error()))
+		return
+	}
+	if _, ok := s.ChannelMap.Get(id); ok {
+		log.Warnf("channel %s existed", id)
+		_ = conn.WriteFrame(iface.OpClose, []byte("channelId is repeated"))
+		return
+	}
+
+	channel := core.NewChannel(id, conn)
+	channel.SetWriteWait(s.options.writewait)
+	channel.SetReadWait(s.options.readwait)
+	s.ChannelMap.Add(channel)
+
+	err = channel.Readloop(s.MessageListener)
+	if err != nil {
+		log.Info(err)
+	}
+	s.ChannelMap.Remove(channel.ID())
+	err = s.Statelistener.Disconnect(channel.ID())
+	if err != nil {
+		log.Warn(err)
+	}
+	channel.Close()
+}
+
+// handleSend 上行消息
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		resp(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	val, ok := s.sessions.Load(r.URL.Query().Get("sid"))
+	if !ok {
+		resp(w, http.StatusNotFound, "session not found")
+		return
+	}
+	conn := val.(*Conn)
+	op, err := ParseOpCode(r.URL.Query().Get("op"))
+	if err != nil {
+		resp(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.options.maxBody))
+	if err != nil {
+		resp(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	err = conn.deliver(&Frame{OpCode: op, Payload: payload})
+	if err == ErrInboxFull {
+		resp(w, http.StatusTooManyRequests, err.Error())
+		return
+	} else if err != nil {
+		resp(w, http.StatusGone, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) Push(id string, data []byte) error {
+	ch, ok := s.ChannelMap.Get(id)
+	if !ok {
+		return errors.Errorf("push to channel [ID]:%s , channel not found", id)
+	}
+	return ch.Push(data)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	log := logger.WithFields(logger.Fields{
+		"module": "sse.server",
+		"id":     s.ServiceID(),
+	})
+
+	s.once.Do(func() {
+		defer func() {
+			log.Infoln("shutdown")
+		}()
+		s.sessions.Range(func(key, val interface{}) bool {
+			val.(*Conn).Close()
+			return true
+		})
+		s.lmu.Lock()
+		s.quit.Fire()
+		srv := s.srv
+		s.lmu.Unlock()
+		if srv != nil {
+			_ = srv.Shutdown(ctx)
+		}
+	})
+	return nil
+}
+
+// SetAcceptor SetAcceptor
+func (s *Server) SetAcceptor(acceptor iface.IAcceptor) {
+	s.Acceptor = acceptor
+}
+
+// SetMessageListener SetMessageListener
+func (s *Server) SetMessageListener(listener iface.IMessageListener) {
+	s.MessageListener = listener
+}
+
+// SetStateListener SetStateListener
+func (s *Server) SetStateListener(listener iface.IStatelistener) {
+	s.Statelistener = listener
+}
+
+// SetChannelMap SetChannelMap
+func (s *Server) SetChannelMap(channels iface.IChannelMap) {
+	s.ChannelMap = channels
+}
+
+// SetReadWait set read wait duration
+func (s *Server) SetReadWait(readwait time.Duration) {
+	s.options.readwait = readwait
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func resp(w http.ResponseWriter, code int, body string) {
+	w.WriteHeader(code)
+	if body != "" {
+		_, _ = w.Write([]byte(body))
+	}
+	logger.Warnf("response with code:%d %s", code, body)
+}
+
+type defaultAcceptor struct {
+}
+
+// Accept defaultAcceptor
+func (a *defaultAcceptor) Accept(conn iface.IConn, timeout time.Duration) (string, error) {
+	return ksuid.New().String(), nil
+}
@@ -0,0 +1,179 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"im/iface"
+	"im/naming"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type echoHandler struct {
+	disconnected chan string
+}
+
+func (h *echoHandler) Receive(ag iface.IAgent, payload []byte) {
+	_ = ag.Push(payload)
+}
+
+func (h *echoHandler) Disconnect(id string) error {
+	h.disconnected <- id
+	return nil
+}
+
+// Accept 第一帧为握手包，内容是客户端id
+func (h *echoHandler) Accept(conn iface.IConn, timeout time.Duration) (string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	return string(frame.GetPayload()), nil
+}
+
+type event struct {
+	name string
+	data string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) event {
+	var e event
+	for {
+		line, err := r.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return e
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func newTestServer(t *testing.T, opts ...Option) (*Server, *echoHandler, *httptest.Server) {
+	h := &echoHandler{disconnected: make(chan string, 1)}
+	srv := NewServer("", naming.NewEntry("sse01", "sse", "sse", "127.0.0.1", 0), opts...).(*Server)
+	srv.SetAcceptor(h)
+	srv.SetMessageListener(h)
+	srv.SetStateListener(h)
+	handler, err := srv.handler()
+	assert.Nil(t, err)
+	return srv, h, httptest.NewServer(handler)
+}
+
+func send(t *testing.T, url, sid, body string) *http.Response {
+	res, err := http.Post(url+"/sse/send?sid="+sid, "application/octet-stream", strings.NewReader(body))
+	assert.Nil(t, err)
+	res.Body.Close()
+	return res
+}
+
+func TestServer(t *testing.T) {
+	srv, h, ts := newTestServer(t)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/sse", nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	r := bufio.NewReader(res.Body)
+
+	// 握手
+	e := readEvent(t, r)
+	assert.Equal(t, "session", e.name)
+	sid := e.data
+	assert.Equal(t, http.StatusNoContent, send(t, ts.URL, sid, "cli01").StatusCode)
+	assert.Eventually(t, func() bool {
+		_, ok := srv.ChannelMap.Get("cli01")
+		return ok
+	}, time.Second, time.Millisecond*10)
+
+	// 上行与下行
+	assert.Equal(t, http.StatusNoContent, send(t, ts.URL, sid, "hello").StatusCode)
+	e = readEvent(t, r)
+	assert.Equal(t, "binary", e.name)
+	payload, _ := base64.StdEncoding.DecodeString(e.data)
+	assert.Equal(t, "hello", string(payload))
+	assert.Equal(t, http.StatusNotFound, send(t, ts.URL, "unknown", "hello").StatusCode)
+
+	// 客户端断开
+	cancel()
+	select {
+	case id := <-h.disconnected:
+		assert.Equal(t, "cli01", id)
+	case <-time.After(time.Second * 2):
+		t.Fatal("disconnect timeout")
+	}
+	assert.Eventually(t, func() bool {
+		_, ok := srv.sessions.Load(sid)
+		return !ok
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, http.StatusNotFound, send(t, ts.URL, sid, "hello").StatusCode)
+}
+
+func TestServerCORS(t *testing.T) {
+	_, _, ts := newTestServer(t, WithAllowedOrigins("https://im.example.com"))
+	defer ts.Close()
+
+	// 预检请求
+	req, _ := http.NewRequest(http.MethodOptions, ts.URL+"/sse/send", nil)
+	req.Header.Set("Origin", "https://im.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "https://im.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, res.Header.Get("Access-Control-Allow-Methods"), "POST")
+
+	// 不允许的Origin
+	for _, path := range []string{"/sse", "/sse/send"} {
+		req, _ = http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		res, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv, h, ts := newTestServer(t)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/sse")
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	r := bufio.NewReader(res.Body)
+	sid := readEvent(t, r).data
+	assert.Equal(t, http.StatusNoContent, send(t, ts.URL, sid, "cli01").StatusCode)
+	assert.Eventually(t, func() bool {
+		_, ok := srv.ChannelMap.Get("cli01")
+		return ok
+	}, time.Second, time.Millisecond*10)
+
+	assert.Nil(t, srv.Shutdown(context.Background()))
+	select {
+	case id := <-h.disconnected:
+		assert.Equal(t, "cli01", id)
+	case <-time.After(time.Second * 2):
+		t.Fatal("disconnect timeout")
+	}
+	// 会话结束后响应结束
+	_, err = r.ReadString('\n')
+	assert.NotNil(t, err)
+}
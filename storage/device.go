@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DevicePolicy 同一个账号在多个设备上登录时的会话策略
+type DevicePolicy int
+
+const (
+	// DeviceSingle 一个账号只保留一个会话，新的登录踢掉之前的会话
+	DeviceSingle DevicePolicy = iota
+	// DevicePerType 每种设备类型保留一个会话，如手机与平板可以同时在线
+	DevicePerType
+	// DevicePerPlatform 每个平台类别(mobile/pc/web)保留一个会话
+	DevicePerPlatform
+	// DeviceUnlimited 不限制会话数量，不会踢掉其它会话
+	DeviceUnlimited
+)
+
+// 平台类别
+const (
+	PlatformMobile = "mobile"
+	PlatformPC     = "pc"
+	PlatformWeb    = "web"
+)
+
+const unknownDevice = "unknown"
+
+// ParseDevicePolicy 解析配置中的策略：single、device、platform、unlimited，为空时使用single
+func ParseDevicePolicy(s string) (DevicePolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "single":
+		return DeviceSingle, nil
+	case "device":
+		return DevicePerType, nil
+	case "platform":
+		return DevicePerPlatform, nil
+	case "unlimited":
+		return DeviceUnlimited, nil
+	}
+	return DeviceSingle, fmt.Errorf("unknown device policy %s", s)
+}
+
+func (p DevicePolicy) String() string {
+	switch p {
+	case DevicePerType:
+		return "device"
+	case DevicePerPlatform:
+		return "platform"
+	case DeviceUnlimited:
+		return "unlimited"
+	}
+	return "single"
+}
+
+// Slot 会话在账号下占用的位置，同一位置只能有一个会话。
+// DeviceSingle时为空，与之前的KeyLocation(account, "")兼容；DeviceUnlimited时每个channel独占一个位置
+func (p DevicePolicy) Slot(device, channelID string) string {
+	switch p {
+	case DevicePerType:
+		device = strings.ToLower(device)
+		if device == "" {
+			return unknownDevice
+		}
+		return device
+	case DevicePerPlatform:
+		return Platform(device)
+	case DeviceUnlimited:
+		return channelID
+	}
+	return ""
+}
+
+// Platform 设备类型所属的平台类别，无法识别的设备类型原样返回
+func Platform(device string) string {
+	device = strings.ToLower(device)
+	switch device {
+	case "android", "ios", "iphone", "ipad", "harmony", "mobile", "pad", "tablet":
+		return PlatformMobile
+	case "windows", "mac", "macos", "osx", "linux", "pc", "desktop":
+		return PlatformPC
+	case "web", "h5", "browser", "mini":
+		return PlatformWeb
+	case "":
+		return unknownDevice
+	}
+	return device
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevicePolicySlot(t *testing.T) {
+	assert.Equal(t, "", DeviceSingle.Slot("iPhone", "ch1"))
+	assert.Equal(t, "iphone", DevicePerType.Slot("iPhone", "ch1"))
+	assert.Equal(t, PlatformMobile, DevicePerPlatform.Slot("iPad", "ch1"))
+	assert.Equal(t, PlatformPC, DevicePerPlatform.Slot("windows", "ch1"))
+	assert.Equal(t, "ch1", DeviceUnlimited.Slot("iPhone", "ch1"))
+
+	for _, s := range []string{"single", "device", "platform", "unlimited"} {
+		p, err := ParseDevicePolicy(s)
+		assert.Nil(t, err)
+		assert.Equal(t, s, p.String())
+	}
+	_, err := ParseDevicePolicy("two")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"im/iface"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/klintcheng/kim/wire/pkt"
+)
+
+type memoryItem struct {
+	session  *pkt.Session
+	deadline time.Time
+}
+
+type memoryLocation struct {
+	loc      iface.Location
+	deadline time.Time
+}
+
+// MemoryStorage 进程内的会话存储，语义与RedisStorage相同，只用于测试：不同的服务之间无法共享。
+// 过期的会话在查询时被过滤，由逻辑服务定时调用RemoveExpired清理
+type MemoryStorage struct {
+	Options
+	sync.RWMutex
+	sessions  map[string]*memoryItem                //channelId -> session
+	locations map[string]map[string]*memoryLocation //account -> slot -> location
+	gates     map[string]map[string]time.Time       //gateId -> channelId -> 登录时间
+	now       func() time.Time                      //测试时替换
+}
+
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
+	m := &MemoryStorage{
+		Options:   newOptions(opts),
+		sessions:  make(map[string]*memoryItem),
+		locations: make(map[string]map[string]*memoryLocation),
+		gates:     make(map[string]map[string]time.Time),
+		now:       time.Now,
+	}
+	return m
+}
+
+// Add 添加会话，会覆盖同一位置上的旧会话，旧会话需要调用方先踢下线
+func (m *MemoryStorage) Add(session *pkt.Session) error {
+	now := m.now()
+	deadline := now.Add(m.expired)
+	slot := m.policy.Slot(session.Device, session.ChannelId)
+
+	m.Lock()
+	defer m.Unlock()
+	slots, ok := m.locations[session.Account]
+	if !ok {
+		slots = make(map[string]*memoryLocation)
+		m.locations[session.Account] = slots
+	}
+	slots[slot] = &memoryLocation{
+		loc: iface.Location{
+			ChannelID: session.ChannelId,
+			GateId:    session.GateId,
+			Account:   session.Account,
+		},
+		deadline: deadline,
+	}
+	// 保存副本，调用方之后修改session不会影响存储
+	m.sessions[session.ChannelId] = &memoryItem{
+		session:  proto.Clone(session).(*pkt.Session),
+		deadline: deadline,
+	}
+	channels, ok := m.gates[session.GateId]
+	if !ok {
+		channels = make(map[string]time.Time)
+		m.gates[session.GateId] = channels
+	}
+	channels[session.ChannelId] = now
+	return nil
+}
+
+// Delete 删除会话，位置已经被新的会话占用时只删除会话本身
+func (m *MemoryStorage) Delete(account string, channelId string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.delete(account, channelId)
+	return nil
+}
+
+func (m *MemoryStorage) delete(account string, channelId string) {
+	var device string
+	if item, ok := m.sessions[channelId]; ok {
+		device = item.session.Device
+		m.removeGate(item.session.GateId, channelId)
+		delete(m.sessions, channelId)
+	}
+	slot := m.policy.Slot(device, channelId)
+	if slots, ok := m.locations[account]; ok {
+		if l, ok := slots[slot]; ok && l.loc.ChannelID == channelId {
+			delete(slots, slot)
+		}
+		if len(slots) == 0 {
+			delete(m.locations, account)
+		}
+	}
+}
+
+func (m *MemoryStorage) removeGate(gateId, channelId string) {
+	if channels, ok := m.gates[gateId]; ok {
+		delete(channels, channelId)
+		if len(channels) == 0 {
+			delete(m.gates, gateId)
+		}
+	}
+}
+
+// PurgeGate 删除网关上所有在before之前登录的会话，返回这些会话的账号
+func (m *MemoryStorage) PurgeGate(gateId string, before time.Time) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	var accounts []string
+	for channelId, login := range m.gates[gateId] {
+		if !login.Before(before) {
+			continue
+		}
+		if item, ok := m.sessions[channelId]; ok {
+			m.delete(item.session.Account, channelId)
+			accounts = append(accounts, item.session.Account)
+		} else {
+			m.removeGate(gateId, channelId)
+		}
+	}
+	return accounts, nil
+}
+
+// Refresh 刷新会话的过期时间，位置已经被新的会话占用时只刷新会话本身
+func (m *MemoryStorage) Refresh(channelId string) error {
+	now := m.now()
+	m.Lock()
+	defer m.Unlock()
+	item, ok := m.sessions[channelId]
+	if !ok || now.After(item.deadline) {
+		return iface.ErrSessionNil
+	}
+	item.deadline = now.Add(m.expired)
+	slot := m.policy.Slot(item.session.Device, channelId)
+	if l, ok := m.locations[item.session.Account][slot]; ok && l.loc.ChannelID == channelId {
+		l.deadline = item.deadline
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Get(channelId string) (*pkt.Session, error) {
+	m.RLock()
+	defer m.RUnlock()
+	item, ok := m.sessions[channelId]
+	if !ok || m.now().After(item.deadline) {
+		return nil, iface.ErrSessionNil
+	}
+	return proto.Clone(item.session).(*pkt.Session), nil
+}
+
+// GetLocation 返回与device占用同一位置的会话，DeviceUnlimited时会话之间不会冲突
+func (m *MemoryStorage) GetLocation(account string, device string) (*iface.Location, error) {
+	if m.policy == DeviceUnlimited {
+		return nil, iface.ErrSessionNil
+	}
+	m.RLock()
+	defer m.RUnlock()
+	l, ok := m.locations[account][m.policy.Slot(device, "")]
+	if !ok || m.now().After(l.deadline) {
+		return nil, iface.ErrSessionNil
+	}
+	loc := l.loc
+	return &loc, nil
+}
+
+// GetLocations 返回账号在所有设备上的位置
+func (m *MemoryStorage) GetLocations(accounts ...string) ([]*iface.Location, error) {
+	now := m.now()
+	m.RLock()
+	defer m.RUnlock()
+	result := make([]*iface.Location, 0, len(accounts))
+	for _, account := range accounts {
+		for _, l := range m.locations[account] {
+			if now.After(l.deadline) {
+				continue
+			}
+			loc := l.loc
+			result = append(result, &loc)
+		}
+	}
+	return result, nil
+}
+
+// RemoveExpired 删除在now之前过期的会话，返回这些会话的账号
+func (m *MemoryStorage) RemoveExpired(now time.Time) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	var accounts []string
+	for channelId, item := range m.sessions {
+		if now.After(item.deadline) {
+			m.removeGate(item.session.GateId, channelId)
+			delete(m.sessions, channelId)
+			accounts = append(accounts, item.session.Account)
+		}
+	}
+	for account, slots := range m.locations {
+		for slot, l := range slots {
+			if now.After(l.deadline) {
+				delete(slots, slot)
+			}
+		}
+		if len(slots) == 0 {
+			delete(m.locations, account)
+		}
+	}
+	return accounts, nil
+}
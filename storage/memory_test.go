@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"im/iface"
+	"testing"
+	"time"
+
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	m := NewMemoryStorage(WithDevicePolicy(DevicePerPlatform))
+
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1", Device: "android"}))
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate1", Account: "test1", Device: "windows"}))
+
+	session, err := m.Get("ch1")
+	assert.Nil(t, err)
+	assert.Equal(t, "test1", session.Account)
+
+	loc, err := m.GetLocation("test1", "ios")
+	assert.Nil(t, err)
+	assert.Equal(t, "ch1", loc.ChannelID)
+
+	locs, _ := m.GetLocations("test1", "test2")
+	assert.Len(t, locs, 2)
+	assert.Equal(t, "test1", locs[0].Account)
+
+	// ch3在同一个平台上登录，之后旧会话ch1注销不影响ch3
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch3", GateId: "gate2", Account: "test1", Device: "ios"}))
+	assert.Nil(t, m.Delete("test1", "ch1"))
+	loc, err = m.GetLocation("test1", "android")
+	assert.Nil(t, err)
+	assert.Equal(t, "ch3", loc.ChannelID)
+	_, err = m.Get("ch1")
+	assert.Equal(t, iface.ErrSessionNil, err)
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestMemoryStorageExpired(t *testing.T) {
+	m := NewMemoryStorage(WithExpired(time.Second * 20))
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	m.now = clock.Now
+
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1"}))
+	_, err := m.GetLocation("test1", "")
+	assert.Nil(t, err)
+
+	// 刷新之后延长过期时间
+	clock.Advance(time.Second * 15)
+	assert.Nil(t, m.Refresh("ch1"))
+	clock.Advance(time.Second * 10)
+	_, err = m.GetLocation("test1", "")
+	assert.Nil(t, err)
+
+	clock.Advance(time.Second * 11)
+	assert.Equal(t, iface.ErrSessionNil, m.Refresh("ch1"))
+	_, err = m.GetLocation("test1", "")
+	assert.Equal(t, iface.ErrSessionNil, err)
+	_, err = m.Get("ch1")
+	assert.Equal(t, iface.ErrSessionNil, err)
+	locs, err := m.GetLocations("test1")
+	assert.Nil(t, err)
+	assert.Empty(t, locs)
+
+	accounts, err := m.RemoveExpired(clock.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test1"}, accounts)
+	assert.Empty(t, m.sessions)
+	assert.Empty(t, m.locations)
+	assert.Empty(t, m.gates)
+}
+
+func TestMemoryStoragePurgeGate(t *testing.T) {
+	m := NewMemoryStorage(WithDevicePolicy(DeviceUnlimited))
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	m.now = clock.Now
+
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1"}))
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate2", Account: "test2"}))
+	restart := clock.Now().Add(time.Second)
+	clock.Advance(time.Second * 2)
+	assert.Nil(t, m.Add(&pkt.Session{ChannelId: "ch3", GateId: "gate1", Account: "test3"}))
+
+	// 只删除重启之前登录的会话
+	accounts, err := m.PurgeGate("gate1", restart)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test1"}, accounts)
+	_, err = m.Get("ch3")
+	assert.Nil(t, err)
+
+	accounts, err = m.PurgeGate("gate1", restart)
+	assert.Nil(t, err)
+	assert.Empty(t, accounts)
+}
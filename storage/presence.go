@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"im/iface"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+// SubscriptionExpired 订阅关系的过期时间，客户端登录后需要重新订阅
+const SubscriptionExpired = time.Hour * 24
+
+const KeyLastSeen = "presence:lastseen"
+
+// KeySubscribers 订阅了account的账号
+func KeySubscribers(account string) string {
+	return fmt.Sprintf("presence:sub:%s", account)
+}
+
+type RedisPresence struct {
+	cli *redis.Client
+}
+
+func NewRedisPresence(cli *redis.Client) iface.IPresenceStorage {
+	return &RedisPresence{
+		cli: cli,
+	}
+}
+
+func (r *RedisPresence) SetLastSeen(account string, t time.Time) error {
+	return r.cli.HSet(KeyLastSeen, account, timestamp(t)).Err()
+}
+
+func (r *RedisPresence) GetLastSeen(accounts ...string) (map[string]int64, error) {
+	result := make(map[string]int64, len(accounts))
+	if len(accounts) == 0 {
+		return result, nil
+	}
+	values, err := r.cli.HMGet(KeyLastSeen, accounts...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if ts, err := strconv.ParseInt(str, 10, 64); err == nil {
+			result[accounts[i]] = ts
+		}
+	}
+	return result, nil
+}
+
+func (r *RedisPresence) Subscribe(subscriber string, accounts ...string) error {
+	pipe := r.cli.Pipeline()
+	for _, account := range accounts {
+		pipe.SAdd(KeySubscribers(account), subscriber)
+		pipe.Expire(KeySubscribers(account), SubscriptionExpired)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+func (r *RedisPresence) Unsubscribe(subscriber string, accounts ...string) error {
+	pipe := r.cli.Pipeline()
+	for _, account := range accounts {
+		pipe.SRem(KeySubscribers(account), subscriber)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+func (r *RedisPresence) Subscribers(account string) ([]string, error) {
+	return r.cli.SMembers(KeySubscribers(account)).Result()
+}
+
+// MemoryPresence 进程内的实现，只用于测试
+type MemoryPresence struct {
+	sync.RWMutex
+	lastSeen    map[string]int64
+	subscribers map[string]map[string]time.Time //account -> subscriber -> 过期时间
+}
+
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		lastSeen:    make(map[string]int64),
+		subscribers: make(map[string]map[string]time.Time),
+	}
+}
+
+func (m *MemoryPresence) SetLastSeen(account string, t time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	m.lastSeen[account] = timestamp(t)
+	return nil
+}
+
+func (m *MemoryPresence) GetLastSeen(accounts ...string) (map[string]int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	result := make(map[string]int64, len(accounts))
+	for _, account := range accounts {
+		if ts, ok := m.lastSeen[account]; ok {
+			result[account] = ts
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryPresence) Subscribe(subscriber string, accounts ...string) error {
+	deadline := time.Now().Add(SubscriptionExpired)
+	m.Lock()
+	defer m.Unlock()
+	for _, account := range accounts {
+		subs, ok := m.subscribers[account]
+		if !ok {
+			subs = make(map[string]time.Time)
+			m.subscribers[account] = subs
+		}
+		subs[subscriber] = deadline
+	}
+	return nil
+}
+
+func (m *MemoryPresence) Unsubscribe(subscriber string, accounts ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, account := range accounts {
+		if subs, ok := m.subscribers[account]; ok {
+			delete(subs, subscriber)
+			if len(subs) == 0 {
+				delete(m.subscribers, account)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryPresence) Subscribers(account string) ([]string, error) {
+	now := time.Now()
+	m.Lock()
+	defer m.Unlock()
+	subs := m.subscribers[account]
+	result := make([]string, 0, len(subs))
+	for subscriber, deadline := range subs {
+		if now.After(deadline) {
+			delete(subs, subscriber)
+			continue
+		}
+		result = append(result, subscriber)
+	}
+	return result, nil
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"fmt"
 	"im/iface"
+	"strconv"
+	"strings"
 	"time"
 
 	redis "github.com/go-redis/redis/v7"
@@ -11,42 +13,224 @@ import (
 )
 
 const (
-	LocationExpired = time.Hour * 48
+	// LocationExpired 会话的默认过期时间，连接活跃时由网关定时刷新
+	LocationExpired = time.Minute * 10
 )
 
+type Options struct {
+	policy  DevicePolicy
+	expired time.Duration
+}
+
+type Option func(opts *Options)
+
+// WithDevicePolicy 设置多设备登录的策略，默认DeviceSingle
+func WithDevicePolicy(policy DevicePolicy) Option {
+	return func(opts *Options) {
+		opts.policy = policy
+	}
+}
+
+// WithExpired 设置会话的过期时间，默认LocationExpired
+func WithExpired(expired time.Duration) Option {
+	return func(opts *Options) {
+		opts.expired = expired
+	}
+}
+
+func newOptions(opts []Option) Options {
+	options := Options{
+		policy:  DeviceSingle,
+		expired: LocationExpired,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
 type RedisStorage struct {
+	Options
 	cli *redis.Client
 }
 
-func NewRedisStoreage(cli *redis.Client) iface.ISessionStorage {
+func NewRedisStoreage(cli *redis.Client, opts ...Option) iface.ISessionStorage {
 	return &RedisStorage{
-		cli: cli,
+		Options: newOptions(opts),
+		cli:     cli,
 	}
 }
 
+// Add 添加会话，会覆盖同一位置上的旧会话，旧会话需要调用方先踢下线
 func (r *RedisStorage) Add(session *pkt.Session) error {
 	loc := iface.Location{
 		ChannelID: session.ChannelId,
 		GateId:    session.GateId,
 	}
-	localKey := KeyLocation(session.Account, "")
-	err := r.cli.Set(localKey, loc.Bytes(), LocationExpired).Err()
-	if err != nil {
-		return err
-	}
-	snKey := KeySession(session.ChannelId)
+	slot := r.policy.Slot(session.Device, session.ChannelId)
 	buf, _ := proto.Marshal(session)
-	return r.cli.Set(snKey, buf, LocationExpired).Err()
+
+	pipe := r.cli.TxPipeline()
+	pipe.Set(KeyLocation(session.Account, slot), loc.Bytes(), r.expired)
+	// 多设备时在账号下记录所有的位置
+	if r.policy != DeviceSingle {
+		pipe.SAdd(KeyDevices(session.Account), slot)
+		pipe.Expire(KeyDevices(session.Account), r.expired)
+	}
+	pipe.Set(KeySession(session.ChannelId), buf, r.expired)
+	// 按网关记录会话与登录时间，网关崩溃时用于清理
+	now := time.Now()
+	pipe.ZAdd(KeyGate(session.GateId), &redis.Z{Score: float64(timestamp(now)), Member: session.ChannelId})
+	pipe.Expire(KeyGate(session.GateId), r.expired)
+	r.addExpiry(pipe, session.Account, session.ChannelId, now)
+	// 会话过期后用于清理网关与设备列表中的记录
+	pipe.HSet(KeyExpiryInfo, session.ChannelId, expiryInfo(session.GateId, slot))
+	_, err := pipe.Exec()
+	return err
 }
 
+// Delete 删除会话，位置已经被新的会话占用时只删除会话本身
 func (r *RedisStorage) Delete(account string, channelId string) error {
-	locKey := KeyLocation(account, "")
-	err := r.cli.Del(locKey).Err()
+	var device, gateId string
+	if session, err := r.Get(channelId); err == nil {
+		device = session.Device
+		gateId = session.GateId
+	} else if err != iface.ErrSessionNil {
+		return err
+	}
+	slot := r.policy.Slot(device, channelId)
+	locKey := KeyLocation(account, slot)
+
+	bts, err := r.cli.Get(locKey).Bytes()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	pipe := r.cli.TxPipeline()
+	var loc iface.Location
+	if err == nil && loc.Unmarshal(bts) == nil && loc.ChannelID == channelId {
+		pipe.Del(locKey)
+		if r.policy != DeviceSingle {
+			pipe.SRem(KeyDevices(account), slot)
+		}
+	}
+	if gateId != "" {
+		pipe.ZRem(KeyGate(gateId), channelId)
+	}
+	pipe.ZRem(KeyExpiry, expiryMember(account, channelId))
+	pipe.HDel(KeyExpiryInfo, channelId)
+	pipe.Del(KeySession(channelId))
+	_, err = pipe.Exec()
+	return err
+}
+
+// Refresh 刷新会话的过期时间，位置已经被新的会话占用时只刷新会话本身
+func (r *RedisStorage) Refresh(channelId string) error {
+	session, err := r.Get(channelId)
 	if err != nil {
 		return err
 	}
-	snKey := KeySession(channelId)
-	return r.cli.Del(snKey).Err()
+	slot := r.policy.Slot(session.Device, channelId)
+	locKey := KeyLocation(session.Account, slot)
+	bts, err := r.cli.Get(locKey).Bytes()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	pipe := r.cli.TxPipeline()
+	var loc iface.Location
+	if err == nil && loc.Unmarshal(bts) == nil && loc.ChannelID == channelId {
+		pipe.Expire(locKey, r.expired)
+		if r.policy != DeviceSingle {
+			pipe.Expire(KeyDevices(session.Account), r.expired)
+		}
+	}
+	pipe.Expire(KeySession(channelId), r.expired)
+	pipe.Expire(KeyGate(session.GateId), r.expired)
+	r.addExpiry(pipe, session.Account, channelId, time.Now())
+	_, err = pipe.Exec()
+	return err
+}
+
+// addExpiry 记录会话的过期时间，会话的key过期时redis不会通知，由RemoveExpired找出这些会话
+func (r *RedisStorage) addExpiry(pipe redis.Pipeliner, account, channelId string, now time.Time) {
+	deadline := timestamp(now.Add(r.expired))
+	pipe.ZAdd(KeyExpiry, &redis.Z{Score: float64(deadline), Member: expiryMember(account, channelId)})
+}
+
+// PurgeGate 删除网关上所有在before之前登录的会话，返回这些会话的账号。
+// 网关重启后新建立的会话不受影响
+func (r *RedisStorage) PurgeGate(gateId string, before time.Time) ([]string, error) {
+	max := "(" + strconv.FormatInt(timestamp(before), 10)
+	channels, err := r.cli.ZRangeByScore(KeyGate(gateId), &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var accounts []string
+	for _, channelId := range channels {
+		session, err := r.Get(channelId)
+		if err == iface.ErrSessionNil {
+			continue
+		}
+		if err != nil {
+			return accounts, err
+		}
+		if err = r.Delete(session.Account, channelId); err != nil {
+			return accounts, err
+		}
+		accounts = append(accounts, session.Account)
+	}
+	err = r.cli.ZRemRangeByScore(KeyGate(gateId), "-inf", max).Err()
+	return accounts, err
+}
+
+// RemoveExpired 找出在now之前过期的会话，返回这些会话的账号，
+// 同时清理网关与设备列表中这些会话的记录。
+// 多个逻辑服务同时清理时，每个会话只会被其中一个返回
+func (r *RedisStorage) RemoveExpired(now time.Time) ([]string, error) {
+	max := strconv.FormatInt(timestamp(now), 10)
+	members, err := r.cli.ZRangeByScore(KeyExpiry, &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var accounts []string
+	for _, member := range members {
+		account, channelId := parseExpiryMember(member)
+		// 会话已经被刷新，还没有过期
+		exists, err := r.cli.Exists(KeySession(channelId)).Result()
+		if err != nil {
+			return accounts, err
+		}
+		if exists > 0 {
+			continue
+		}
+		info, err := r.cli.HGet(KeyExpiryInfo, channelId).Result()
+		if err != nil && err != redis.Nil {
+			return accounts, err
+		}
+		gateId, slot := parseExpiryInfo(info)
+		// 位置已经被新的会话占用时保留设备列表中的记录
+		var locExists int64
+		if r.policy != DeviceSingle && info != "" {
+			if locExists, err = r.cli.Exists(KeyLocation(account, slot)).Result(); err != nil {
+				return accounts, err
+			}
+		}
+		pipe := r.cli.TxPipeline()
+		removed := pipe.ZRem(KeyExpiry, member)
+		pipe.HDel(KeyExpiryInfo, channelId)
+		if gateId != "" {
+			pipe.ZRem(KeyGate(gateId), channelId)
+		}
+		if r.policy != DeviceSingle && info != "" && locExists == 0 {
+			pipe.SRem(KeyDevices(account), slot)
+		}
+		if _, err = pipe.Exec(); err != nil {
+			return accounts, err
+		}
+		if removed.Val() > 0 {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
 }
 
 func (r *RedisStorage) Get(channelId string) (*pkt.Session, error) {
@@ -63,8 +247,12 @@ func (r *RedisStorage) Get(channelId string) (*pkt.Session, error) {
 	return &session, err
 }
 
+// GetLocation 返回与device占用同一位置的会话，DeviceUnlimited时会话之间不会冲突
 func (r *RedisStorage) GetLocation(account string, device string) (*iface.Location, error) {
-	key := KeyLocation(account, device)
+	if r.policy == DeviceUnlimited {
+		return nil, iface.ErrSessionNil
+	}
+	key := KeyLocation(account, r.policy.Slot(device, ""))
 	bts, err := r.cli.Get(key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
@@ -74,27 +262,60 @@ func (r *RedisStorage) GetLocation(account string, device string) (*iface.Locati
 	}
 	var loc iface.Location
 	loc.Unmarshal(bts)
+	loc.Account = account
 	return &loc, nil
 }
 
+// GetLocations 返回账号在所有设备上的位置
 func (r *RedisStorage) GetLocations(accounts ...string) ([]*iface.Location, error) {
-	keys := KeyLocations(accounts...)
+	keys, owners, err := r.locationKeys(accounts)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return []*iface.Location{}, nil
+	}
 	list, err := r.cli.MGet(keys...).Result()
 	if err != nil {
 		return nil, err
 	}
 	result := make([]*iface.Location, 0, len(list))
-	for _, l := range list {
+	for i, l := range list {
 		if l == nil {
 			continue
 		}
 		var loc iface.Location
 		loc.Unmarshal([]byte(l.(string)))
+		loc.Account = owners[i]
 		result = append(result, &loc)
 	}
 	return result, nil
 }
 
+// locationKeys 返回账号所有位置的key，以及每个key所属的账号
+func (r *RedisStorage) locationKeys(accounts []string) ([]string, []string, error) {
+	if r.policy == DeviceSingle {
+		return KeyLocations(accounts...), accounts, nil
+	}
+	pipe := r.cli.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(accounts))
+	for i, account := range accounts {
+		cmds[i] = pipe.SMembers(KeyDevices(account))
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(accounts))
+	owners := make([]string, 0, len(accounts))
+	for i, account := range accounts {
+		for _, slot := range cmds[i].Val() {
+			keys = append(keys, KeyLocation(account, slot))
+			owners = append(owners, account)
+		}
+	}
+	return keys, owners, nil
+}
+
 func KeySession(channel string) string {
 	return fmt.Sprintf("login:sn:%s", channel)
 }
@@ -113,3 +334,49 @@ func KeyLocations(accounts ...string) []string {
 	}
 	return arr
 }
+
+// KeyGate 网关上的所有会话
+func KeyGate(gateId string) string {
+	return fmt.Sprintf("login:gate:%s", gateId)
+}
+
+func timestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// KeyExpiry 所有会话的过期时间，成员为expiryMember
+const KeyExpiry = "login:expiry"
+
+// expiryMember channelId中不包含空格，账号放在后面
+func expiryMember(account, channelId string) string {
+	return channelId + " " + account
+}
+
+func parseExpiryMember(member string) (account, channelId string) {
+	i := strings.IndexByte(member, ' ')
+	if i < 0 {
+		return "", member
+	}
+	return member[i+1:], member[:i]
+}
+
+// KeyExpiryInfo 会话所在的网关与位置，channelId -> expiryInfo，会话的key过期之后用于清理
+const KeyExpiryInfo = "login:expiry:info"
+
+// expiryInfo gateId中不包含空格，位置放在后面
+func expiryInfo(gateId, slot string) string {
+	return gateId + " " + slot
+}
+
+func parseExpiryInfo(info string) (gateId, slot string) {
+	i := strings.IndexByte(info, ' ')
+	if i < 0 {
+		return info, ""
+	}
+	return info[:i], info[i+1:]
+}
+
+// KeyDevices 账号下所有会话的位置
+func KeyDevices(account string) string {
+	return fmt.Sprintf("login:devices:%s", account)
+}
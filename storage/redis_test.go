@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"im/iface"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v7"
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedisStorage(t *testing.T, opts ...Option) (iface.ISessionStorage, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	return NewRedisStoreage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), opts...), mr
+}
+
+func TestRedisStorageLocations(t *testing.T) {
+	r, mr := newRedisStorage(t, WithDevicePolicy(DevicePerPlatform))
+	defer mr.Close()
+
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1", Device: "android"}))
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate1", Account: "test1", Device: "windows"}))
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch3", GateId: "gate2", Account: "test2", Device: "ios"}))
+
+	locs, err := r.GetLocations("test1", "test2", "test3")
+	assert.Nil(t, err)
+	owners := make(map[string]string)
+	for _, loc := range locs {
+		owners[loc.ChannelID] = loc.Account
+	}
+	assert.Equal(t, map[string]string{"ch1": "test1", "ch2": "test1", "ch3": "test2"}, owners)
+
+	loc, err := r.GetLocation("test2", "android")
+	assert.Nil(t, err)
+	assert.Equal(t, "ch3", loc.ChannelID)
+	assert.Equal(t, "test2", loc.Account)
+}
+
+func TestRedisStorageRemoveExpired(t *testing.T) {
+	r, mr := newRedisStorage(t, WithExpired(time.Second*10))
+	defer mr.Close()
+
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1"}))
+	// 注销的会话不会再被当作过期
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate1", Account: "test2"}))
+	assert.Nil(t, r.Delete("test2", "ch2"))
+	mr.FastForward(time.Second * 11)
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch3", GateId: "gate1", Account: "test3"}))
+
+	// ch3的key还没有过期，留到下一次清理
+	accounts, err := r.RemoveExpired(time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test1"}, accounts)
+
+	accounts, err = r.RemoveExpired(time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, accounts)
+
+	mr.FastForward(time.Second * 11)
+	accounts, err = r.RemoveExpired(time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test3"}, accounts)
+	assert.False(t, mr.Exists(KeyExpiry))
+	assert.False(t, mr.Exists(KeyExpiryInfo))
+	assert.False(t, mr.Exists(KeyGate("gate1")))
+}
+
+// 过期的会话从网关与设备列表中移除，被新会话占用的位置保留
+func TestRedisStorageRemoveExpiredMembers(t *testing.T) {
+	r, mr := newRedisStorage(t, WithDevicePolicy(DevicePerType), WithExpired(time.Minute))
+	defer mr.Close()
+
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1", Device: "iphone"}))
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate1", Account: "test1", Device: "ipad"}))
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch3", GateId: "gate2", Account: "test1", Device: "windows"}))
+	// ch1与ch3过期，ch2仍然活跃；ch3的位置之后被ch4占用
+	mr.SetTTL(KeySession("ch1"), time.Second)
+	mr.SetTTL(KeyLocation("test1", "iphone"), time.Second)
+	mr.SetTTL(KeySession("ch3"), time.Second)
+	mr.FastForward(time.Second * 2)
+	_, err := mr.ZAdd(KeyGate("gate2"), float64(timestamp(time.Now())), "ch4")
+	assert.Nil(t, err)
+	assert.Nil(t, mr.Set(KeyLocation("test1", "windows"), "ch4"))
+
+	accounts, err := r.RemoveExpired(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test1", "test1"}, accounts)
+
+	members, err := mr.ZMembers(KeyGate("gate1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ch2"}, members)
+	members, err = mr.ZMembers(KeyGate("gate2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ch4"}, members)
+	slots, err := mr.SMembers(KeyDevices("test1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ipad", "windows"}, slots)
+	fields, err := mr.HKeys(KeyExpiryInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ch2"}, fields)
+}
+
+func TestRedisStoragePurgeGate(t *testing.T) {
+	r, mr := newRedisStorage(t, WithDevicePolicy(DeviceUnlimited))
+	defer mr.Close()
+
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch1", GateId: "gate1", Account: "test1"}))
+	assert.Nil(t, r.Add(&pkt.Session{ChannelId: "ch2", GateId: "gate2", Account: "test2"}))
+
+	accounts, err := r.PurgeGate("gate1", time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test1"}, accounts)
+	_, err = r.Get("ch1")
+	assert.Equal(t, iface.ErrSessionNil, err)
+	_, err = r.Get("ch2")
+	assert.Nil(t, err)
+
+	// 清理过的会话不会再被当作过期
+	accounts, err = r.RemoveExpired(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Empty(t, accounts)
+}
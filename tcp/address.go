@@ -0,0 +1,13 @@
+package tcp
+
+import "strings"
+
+const unixPrefix = "unix://"
+
+// ParseAddress 解析监听或拨号地址，unix://path 使用unix socket，其它使用tcp
+func ParseAddress(addr string) (network, address string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+	return "tcp", addr
+}
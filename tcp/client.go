@@ -1,8 +1,10 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"im/core"
 	"im/iface"
 	"im/logger"
 	"net/url"
@@ -15,6 +17,19 @@ type ClientOptions struct {
 	Heartbeat time.Duration
 	ReadWait  time.Duration
 	WriteWait time.Duration
+	TLSConfig *tls.Config //不为空时使用tls连接服务端
+	//消息帧最大长度，为0时使用iface.DefaultMaxPayload
+	MaxPayload int
+	//心跳连续MaxMissedPongs次没有收到pong时关闭连接，为0时使用iface.DefaultMaxMissedPongs
+	MaxMissedPongs int
+	//断线后自动重新拨号并握手，重试间隔在ReconnectMin与ReconnectMax之间指数增长，
+	//MaxRetries为0时不限制重试次数
+	Reconnect    bool
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+	MaxRetries   int
+	//连接状态变化时回调，err为断开的原因
+	OnStateChange func(state iface.ConnState, err error)
 }
 
 type Client struct {
@@ -23,9 +38,12 @@ type Client struct {
 	once    sync.Once
 	id      string
 	name    string
+	addr    string
 	conn    iface.IConn
 	state   int32
 	options ClientOptions
+	closed  *core.Event
+	pings   core.PingTracker
 	Meta    map[string]string
 }
 
@@ -37,11 +55,18 @@ func NewClient(id, name string, opts ClientOptions) iface.IClient {
 	if opts.ReadWait == 0 {
 		opts.ReadWait = iface.DefaultReadWait
 	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = iface.DefaultMaxPayload
+	}
+	if opts.MaxMissedPongs == 0 {
+		opts.MaxMissedPongs = iface.DefaultMaxMissedPongs
+	}
 	fmt.Printf("%#v", opts)
 	cli := &Client{
 		id:      id,
 		name:    name,
 		options: opts,
+		closed:  core.NewEvent(),
 		Meta:    make(map[string]string),
 	}
 	return cli
@@ -55,12 +80,19 @@ func NewClientWithProps(id, name string, meta map[string]string, opts ClientOpti
 	if opts.ReadWait == 0 {
 		opts.ReadWait = iface.DefaultReadWait
 	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = iface.DefaultMaxPayload
+	}
+	if opts.MaxMissedPongs == 0 {
+		opts.MaxMissedPongs = iface.DefaultMaxMissedPongs
+	}
 
 	fmt.Printf("after %#v\n", opts)
 	cli := &Client{
 		id:      id,
 		name:    name,
 		options: opts,
+		closed:  core.NewEvent(),
 		Meta:    meta,
 	}
 	return cli
@@ -72,62 +104,159 @@ func (c *Client) Connect(addr string) error {
 		return err
 	}
 
-	if !atomic.CompareAndSwapInt32(&c.state, 0, 1) {
+	if !atomic.CompareAndSwapInt32(&c.state, int32(iface.ConnDisconnected), int32(iface.ConnConnected)) {
 		return fmt.Errorf("connection is connected")
 	}
+	c.addr = addr
 
+	conn, err := c.dial()
+	if err != nil {
+		atomic.CompareAndSwapInt32(&c.state, int32(iface.ConnConnected), int32(iface.ConnDisconnected))
+		return err
+	}
+	c.Lock()
+	c.conn = conn
+	c.Unlock()
+	c.startHeartbeat(conn)
+	c.notify(iface.ConnConnected, nil)
+	return nil
+}
+
+// dial 通过IDialer拨号并完成握手
+func (c *Client) dial() (iface.IConn, error) {
 	rawconn, err := c.DialAndHandshake(iface.DialerContext{
-		Id:      c.id,
-		Name:    c.name,
-		Address: addr,
-		Timeout: iface.DefaultLoginWait,
+		Id:        c.id,
+		Name:      c.name,
+		Address:   c.addr,
+		Timeout:   iface.DefaultLoginWait,
+		TLSConfig: c.options.TLSConfig,
+		Meta:      c.Meta,
 	})
-
 	if err != nil {
-		atomic.CompareAndSwapInt32(&c.state, 1, 0)
-		return err
+		return nil, err
 	}
 
 	if rawconn == nil {
-		return fmt.Errorf("conn is nil")
+		return nil, fmt.Errorf("conn is nil")
 	}
 
-	c.conn = NewTcpConn(rawconn)
+	// 拨号器已经使用带版本的帧格式完成了握手
+	if conn, ok := rawconn.(*TcpConn); ok {
+		conn.maxPayload = c.options.MaxPayload
+		return conn, nil
+	}
+	return NewTcpConnWithLimit(rawconn, c.options.MaxPayload), nil
+}
 
+func (c *Client) startHeartbeat(conn iface.IConn) {
+	c.pings.Reset()
 	if c.options.Heartbeat > 0 {
 		//心跳处理
 		go func() {
-			err := c.heartbealoop()
+			err := c.heartbealoop(conn)
 			if err != nil {
 				logger.WithField("module", "tcp.client").Warn("heartbealoop stopped - ", err)
 			}
 		}()
 	}
-	return nil
 }
 
+// Read 开启Reconnect时，连接断开后会重连并继续读取，直到重连失败或调用了Close
 func (c *Client) Read() (iface.IFrame, error) {
-	if c.conn == nil {
-		return nil, errors.New("conn is nil")
-	}
+	for {
+		c.Lock()
+		conn := c.conn
+		c.Unlock()
+		if conn == nil {
+			return nil, errors.New("conn is nil")
+		}
 
-	if c.options.Heartbeat > 0 {
-		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+		if c.options.Heartbeat > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+		}
+		frame, err := conn.ReadFrame()
+		if err == nil {
+			if frame.GetOpCode() == iface.OpPong {
+				c.pings.Pong()
+			}
+			if frame.GetOpCode() == iface.OpClose {
+				return frame, errors.New("conn is closed")
+			}
+			return frame, nil
+		}
+		var tooLarge *iface.FrameTooLargeError
+		if errors.As(err, &tooLarge) {
+			c.closeWithReason(err.Error())
+			return frame, err
+		}
+		if !c.options.Reconnect || c.closed.HasFired() {
+			return frame, err
+		}
+		if err = c.reconnect(conn, err); err != nil {
+			return nil, err
+		}
 	}
-	frame, err := c.conn.ReadFrame()
-	if err != nil {
-		return frame, err
+}
+
+// reconnect 关闭断开的连接，按退避间隔重新拨号
+func (c *Client) reconnect(old iface.IConn, cause error) error {
+	log := logger.WithFields(logger.Fields{
+		"module": "tcp.client",
+		"id":     c.id,
+	})
+	_ = old.Close()
+	c.notify(iface.ConnReconnecting, cause)
+
+	backoff := core.Backoff{Min: c.options.ReconnectMin, Max: c.options.ReconnectMax}
+	var err error
+	for attempt := 0; c.options.MaxRetries == 0 || attempt < c.options.MaxRetries; attempt++ {
+		select {
+		case <-time.After(backoff.Duration(attempt)):
+		case <-c.closed.Done():
+			return errors.New("client is closed")
+		}
+		var conn iface.IConn
+		if conn, err = c.dial(); err != nil {
+			log.Warnf("reconnect to %s failed - %v", c.addr, err)
+			continue
+		}
+		c.Lock()
+		if c.closed.HasFired() {
+			c.Unlock()
+			_ = conn.Close()
+			return errors.New("client is closed")
+		}
+		c.conn = conn
+		c.Unlock()
+		c.startHeartbeat(conn)
+		log.Infof("reconnected to %s", c.addr)
+		c.notify(iface.ConnConnected, nil)
+		return nil
 	}
+	c.notify(iface.ConnDisconnected, err)
+	return fmt.Errorf("reconnect to %s failed: %v", c.addr, err)
+}
 
-	if frame.GetOpCode() == iface.OpClose {
-		return frame, errors.New("conn is closed")
+func (c *Client) notify(state iface.ConnState, err error) {
+	atomic.StoreInt32(&c.state, int32(state))
+	if c.options.OnStateChange != nil {
+		c.options.OnStateChange(state, err)
 	}
-	return frame, nil
+}
+
+// RTT 最近一次心跳的往返时间，没有开启心跳或还没有收到pong时为0
+func (c *Client) RTT() time.Duration {
+	return c.pings.RTT()
+}
+
+// State 返回当前的连接状态
+func (c *Client) State() iface.ConnState {
+	return iface.ConnState(atomic.LoadInt32(&c.state))
 }
 
 func (c *Client) Send(payload []byte) error {
-	if atomic.LoadInt32(&c.state) == 0 {
-		return fmt.Errorf("conn is nil")
+	if c.State() != iface.ConnConnected {
+		return fmt.Errorf("conn is not connected")
 	}
 	c.Lock()
 	defer c.Unlock()
@@ -156,30 +285,65 @@ func (c *Client) GetMeta() map[string]string {
 
 func (c *Client) Close() {
 	c.once.Do(func() {
-		if c.conn == nil {
+		c.closed.Fire()
+		c.Lock()
+		conn := c.conn
+		if conn != nil {
+			_ = conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			_ = conn.WriteFrame(iface.OpClose, nil)
+		}
+		c.Unlock()
+		if conn == nil {
 			return
 		}
-		_ = c.conn.WriteFrame(iface.OpClose, nil)
+		conn.Close()
+		c.notify(iface.ConnClosed, nil)
+	})
+}
+
+// closeWithReason 发送带有原因的OpClose后关闭连接
+func (c *Client) closeWithReason(reason string) {
+	c.once.Do(func() {
+		c.closed.Fire()
+		c.Lock()
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+		_ = c.conn.WriteFrame(iface.OpClose, []byte(reason))
+		c.Unlock()
 		c.conn.Close()
-		atomic.CompareAndSwapInt32(&c.state, 1, 0)
+		c.notify(iface.ConnClosed, nil)
 	})
 }
 
-func (c *Client) heartbealoop() error {
+// heartbealoop 定时发送ping，连续MaxMissedPongs次没有收到pong时关闭连接，
+// 使Read返回错误(或触发重连)，用于发现半开的连接
+func (c *Client) heartbealoop(conn iface.IConn) error {
 	tick := time.NewTicker(c.options.Heartbeat)
+	defer tick.Stop()
 	for range tick.C {
-		if err := c.ping(); err != nil {
+		if missed := c.pings.Outstanding(); missed >= c.options.MaxMissedPongs {
+			_ = conn.Close()
+			return fmt.Errorf("%d pongs missed, peer is dead", missed)
+		}
+		if err := c.ping(conn); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *Client) ping() error {
+// ping 与Send、Close持有同一个锁，避免同时写入连接；重连之后旧连接的心跳退出
+func (c *Client) ping(conn iface.IConn) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn != conn {
+		return errors.New("connection is replaced")
+	}
 	logger.WithField("module", "tcp.client").Tracef("%s send ping to server", c.id)
-	err := c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+	err := conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	if err != nil {
 		return err
 	}
-	return c.conn.WriteFrame(iface.OpPing, nil)
+	// 在写入之前记录，pong可能在WriteFrame返回之前到达
+	c.pings.Ping()
+	return conn.WriteFrame(iface.OpPing, nil)
 }
@@ -0,0 +1,117 @@
+package tcp
+
+import (
+	"im/iface"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type netDialer struct{}
+
+func (netDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
+	return net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+}
+
+// peerServer 接受连接并把收到的消息帧转发到frames，kill关闭监听与所有连接
+type peerServer struct {
+	sync.Mutex
+	lis    net.Listener
+	conns  []net.Conn
+	frames chan iface.IFrame
+}
+
+func listenPeer(t *testing.T, addr string, frames chan iface.IFrame) *peerServer {
+	lis, err := net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	s := &peerServer{lis: lis, frames: frames}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			s.Lock()
+			s.conns = append(s.conns, conn)
+			s.Unlock()
+			go func() {
+				tc := NewTcpConn(conn)
+				for {
+					frame, err := tc.ReadFrame()
+					if err != nil {
+						return
+					}
+					if frame.GetOpCode() == iface.OpPing {
+						_ = tc.WriteFrame(iface.OpPong, nil)
+						continue
+					}
+					frames <- frame
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *peerServer) kill() {
+	s.Lock()
+	defer s.Unlock()
+	_ = s.lis.Close()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// 服务端断开后，客户端按退避间隔重连，重连之后可以继续发送
+func TestClientReconnect(t *testing.T) {
+	frames := make(chan iface.IFrame, 16)
+	srv := listenPeer(t, "127.0.0.1:0", frames)
+	addr := srv.lis.Addr().String()
+
+	states := make(chan iface.ConnState, 16)
+	cli := NewClient("c1", "test", ClientOptions{
+		Heartbeat:    time.Millisecond * 20,
+		Reconnect:    true,
+		ReconnectMin: time.Millisecond * 20,
+		ReconnectMax: time.Millisecond * 100,
+		OnStateChange: func(state iface.ConnState, err error) {
+			states <- state
+		},
+	})
+	cli.SetDialer(netDialer{})
+	_, port, err := net.SplitHostPort(addr)
+	assert.Nil(t, err)
+	// url.Parse不接受ip:port
+	assert.Nil(t, cli.Connect("localhost:"+port))
+	defer cli.Close()
+	assert.Equal(t, iface.ConnConnected, <-states)
+	go func() {
+		for {
+			if _, err := cli.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	assert.Nil(t, cli.Send([]byte("a")))
+	assert.Equal(t, []byte("a"), (<-frames).GetPayload())
+
+	srv.kill()
+	assert.Equal(t, iface.ConnReconnecting, <-states)
+	// 服务端不可用期间重连失败，之后在同一地址恢复
+	time.Sleep(time.Millisecond * 100)
+	srv = listenPeer(t, addr, frames)
+	defer srv.kill()
+
+	select {
+	case state := <-states:
+		assert.Equal(t, iface.ConnConnected, state)
+	case <-time.After(time.Second * 3):
+		t.Fatal("reconnect timeout")
+	}
+	assert.Nil(t, cli.Send([]byte("b")))
+	assert.Equal(t, []byte("b"), (<-frames).GetPayload())
+}
@@ -1,28 +1,182 @@
 package tcp
 
 import (
+	"bufio"
+	"bytes"
 	"im/iface"
 	"im/wire/endian"
 	"io"
 	"net"
+	"sync"
+	"time"
+)
+
+const (
+	formatUnknown = iota
+	formatLegacy
+	formatVersioned
 )
 
 type TcpConn struct {
 	net.Conn
+	maxPayload int
+	mode       FrameMode
+	offer      uint8   //本端支持的能力
+	threshold  int     //压缩阈值
+	hdr        [8]byte //读取帧头时复用，读取只在一个协程中进行
+
+	wmu sync.Mutex
+	wr  *bufio.Writer //不为空时WriteFrame只写入缓冲，由Flush发送
+
+	sync.Mutex
+	format     int
+	version    uint8
+	flags      uint8 //协商后的能力
+	negotiated bool
 }
 
+// NewTcpConn 只使用旧的帧格式
 func NewTcpConn(conn net.Conn) *TcpConn {
 	return &TcpConn{
-		Conn: conn,
+		Conn:   conn,
+		mode:   ModeLegacy,
+		format: formatLegacy,
+	}
+}
+
+// NewTcpConnWithLimit 读取超过maxPayload的消息帧时返回FrameTooLargeError
+func NewTcpConnWithLimit(conn net.Conn, maxPayload int) *TcpConn {
+	c := NewTcpConn(conn)
+	c.maxPayload = maxPayload
+	return c
+}
+
+// NewServerConn 服务端连接，帧格式与能力在读取第一帧时确定
+func NewServerConn(conn net.Conn, mode FrameMode, offer uint8, maxPayload int) *TcpConn {
+	c := &TcpConn{
+		Conn:       conn,
+		maxPayload: maxPayload,
+		mode:       mode,
+		offer:      offer & SupportedFlags,
+		threshold:  DefaultCompressThreshold,
+	}
+	if mode == ModeLegacy {
+		c.format = formatLegacy
+	}
+	return c
+}
+
+// NewVersionedConn 客户端连接，使用带版本的帧格式并在握手时提供offer中的能力
+func NewVersionedConn(conn net.Conn, offer uint8) *TcpConn {
+	return &TcpConn{
+		Conn:      conn,
+		mode:      ModeVersioned,
+		offer:     offer & SupportedFlags,
+		threshold: DefaultCompressThreshold,
+		format:    formatVersioned,
+		version:   ProtocolVersion,
+		flags:     offer & SupportedFlags,
+	}
+}
+
+// SetWriteBuffer 开启大小为size的写缓冲，之后写入的消息帧需要调用Flush才会发送，
+// size不大于0时关闭写缓冲。需要在连接开始写入之前调用
+func (c *TcpConn) SetWriteBuffer(size int) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if size > 0 {
+		c.wr = bufio.NewWriterSize(c.Conn, size)
+	} else {
+		c.wr = nil
+	}
+}
+
+// Version 返回协商后的协议版本，旧格式返回0
+func (c *TcpConn) Version() uint8 {
+	c.Lock()
+	defer c.Unlock()
+	return c.version
+}
+
+// Flags 返回协商后的能力
+func (c *TcpConn) Flags() uint8 {
+	c.Lock()
+	defer c.Unlock()
+	if !c.negotiated {
+		return 0
 	}
+	return c.flags
 }
 
 func (c *TcpConn) ReadFrame() (iface.IFrame, error) {
-	opcode, err := endian.ReadUint8(c.Conn)
-	if err != nil {
+	return c.readFrame(c.Conn)
+}
+
+// readFrame 从r中读取一个消息帧，r为连接或者事件循环中已经读取的完整消息帧
+func (c *TcpConn) readFrame(r io.Reader) (iface.IFrame, error) {
+	if _, err := io.ReadFull(r, c.hdr[:1]); err != nil {
 		return nil, err
 	}
-	payload, err := endian.ReadBytes(c.Conn)
+	first := c.hdr[0]
+
+	c.Lock()
+	if c.format == formatUnknown {
+		if first == Magic0 {
+			c.format = formatVersioned
+		} else {
+			c.format = formatLegacy
+		}
+	}
+	format := c.format
+	c.Unlock()
+
+	if format == formatLegacy {
+		if c.mode == ModeVersioned {
+			return nil, ErrLegacyFrame
+		}
+		return c.readLegacy(r, first)
+	}
+	if first != Magic0 {
+		return nil, ErrLegacyFrame
+	}
+	return c.readVersioned(r)
+}
+
+// frameSize 返回buf开头的消息帧的总长度，buf中的数据还不足以确定长度时返回0
+func (c *TcpConn) frameSize(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	c.Lock()
+	format := c.format
+	c.Unlock()
+	if format == formatUnknown {
+		if buf[0] == Magic0 {
+			format = formatVersioned
+		} else {
+			format = formatLegacy
+		}
+	}
+	// 旧格式 opcode(1) | length(4)，带版本的格式 magic(2) | version(1) | flags(1) | opcode(1) | length(4)
+	hdrlen := 5
+	if format == formatVersioned {
+		hdrlen = 9
+	}
+	if len(buf) < hdrlen {
+		return 0, nil
+	}
+	length := endian.Default.Uint32(buf[hdrlen-4 : hdrlen])
+	if c.maxPayload > 0 && int64(length) > int64(c.maxPayload) {
+		return 0, &iface.FrameTooLargeError{Size: uint64(length), Max: c.maxPayload}
+	}
+	return hdrlen + int(length), nil
+}
+
+func (c *TcpConn) readLegacy(r io.Reader, opcode uint8) (iface.IFrame, error) {
+	if _, err := io.ReadFull(r, c.hdr[:4]); err != nil {
+		return nil, err
+	}
+	payload, err := c.readPayload(r, endian.Default.Uint32(c.hdr[:4]))
 	if err != nil {
 		return nil, err
 	}
@@ -32,12 +186,149 @@ func (c *TcpConn) ReadFrame() (iface.IFrame, error) {
 	}, nil
 }
 
+func (c *TcpConn) readVersioned(r io.Reader) (iface.IFrame, error) {
+	h, err := readHeader(r, c.hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	flags := c.negotiate(h)
+	// 没有协商压缩时对端不应发送压缩的帧
+	compressed := h.flags&flagCompressed != 0
+	if compressed && flags&FlagCompression == 0 {
+		return nil, ErrNotNegotiated
+	}
+
+	payload, err := c.readPayload(r, h.length)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		if payload, err = decompress(payload, c.maxPayload); err != nil {
+			return nil, err
+		}
+	}
+	return &Frame{
+		OpCode:  iface.OpCode(h.opcode),
+		Payload: payload,
+	}, nil
+}
+
+// negotiate 以收到的第一帧确定协议版本与双方共同支持的能力，返回协商后的能力
+func (c *TcpConn) negotiate(h header) uint8 {
+	c.Lock()
+	defer c.Unlock()
+	if c.negotiated {
+		return c.flags
+	}
+	c.version = h.version
+	if c.version > ProtocolVersion {
+		c.version = ProtocolVersion
+	}
+	c.flags = h.flags & c.offer
+	c.negotiated = true
+	return c.flags
+}
+
+// AckHandshake 服务端读取握手帧之后调用。带版本的连接回复一个空的消息帧，
+// 帧头中是协商后的能力，客户端据此在握手时确定能力；旧格式的对端不等待回复
+func (c *TcpConn) AckHandshake() error {
+	c.Lock()
+	format := c.format
+	c.Unlock()
+	if format != formatVersioned {
+		return nil
+	}
+	if err := c.WriteFrame(iface.OpBinary, nil); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// WaitHandshakeAck 客户端发送握手帧之后调用，读取服务端的回复并确定协商后的能力
+func (c *TcpConn) WaitHandshakeAck(timeout time.Duration) error {
+	_ = c.SetReadDeadline(time.Now().Add(timeout))
+	frame, err := c.ReadFrame()
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	if frame.GetOpCode() != iface.OpBinary || len(frame.GetPayload()) != 0 {
+		return ErrHandshakeAck
+	}
+	return nil
+}
+
+// readPayload 返回新分配的payload，消息由其它协程异步处理，不能复用读取的缓冲
+func (c *TcpConn) readPayload(r io.Reader, length uint32) ([]byte, error) {
+	if c.maxPayload > 0 && int64(length) > int64(c.maxPayload) {
+		return nil, &iface.FrameTooLargeError{Size: uint64(length), Max: c.maxPayload}
+	}
+	return endian.ReadFixedBytes(int(length), r)
+}
+
 func (c *TcpConn) WriteFrame(code iface.OpCode, payload []byte) error {
-	return WriteFrame(c.Conn, code, payload)
+	c.Lock()
+	format, version, flags, negotiated := c.format, c.version, c.flags, c.negotiated
+	c.Unlock()
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if format != formatVersioned {
+		if c.wr != nil {
+			return WriteFrame(c.wr, code, payload)
+		}
+		return WriteFrame(c.Conn, code, payload)
+	}
+	if negotiated && flags&FlagCompression != 0 && len(payload) >= c.threshold {
+		compressed, err := compress(payload)
+		if err != nil {
+			return err
+		}
+		payload = compressed
+		flags |= flagCompressed
+	}
+	h := header{
+		version: version,
+		flags:   flags,
+		opcode:  uint8(code),
+		length:  uint32(len(payload)),
+	}
+	if c.wr != nil {
+		if err := writeHeader(c.wr, h); err != nil {
+			return err
+		}
+		_, err := c.wr.Write(payload)
+		return err
+	}
+	var buf bytes.Buffer
+	_ = writeHeader(&buf, h)
+	buf.Write(payload)
+	_, err := c.Conn.Write(buf.Bytes())
+	return err
 }
 
+// Flush 发送写缓冲中的消息帧
 func (c *TcpConn) Flush() error {
-	return nil
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.wr == nil {
+		return nil
+	}
+	return c.wr.Flush()
+}
+
+// closeFlushWait 关闭连接时发送剩余缓冲的超时时间，避免对端不读取时阻塞
+const closeFlushWait = time.Second
+
+// Close 关闭之前尽量发送写缓冲中的数据
+func (c *TcpConn) Close() error {
+	c.wmu.Lock()
+	if c.wr != nil && c.wr.Buffered() > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(closeFlushWait))
+		_ = c.wr.Flush()
+	}
+	c.wmu.Unlock()
+	return c.Conn.Close()
 }
 
 func WriteFrame(w io.Writer, code iface.OpCode, payload []byte) error {
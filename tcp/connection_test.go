@@ -0,0 +1,55 @@
+package tcp
+
+import (
+	"im/iface"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// benchmarkWrite 模拟wirteloop，每batch个消息帧Flush一次
+func benchmarkWrite(b *testing.B, bufsize, batch int) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		peer, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(ioutil.Discard, peer)
+	}()
+	raw, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	conn := NewServerConn(raw, ModeLegacy, 0, 0)
+	conn.SetWriteBuffer(bufsize)
+	defer conn.Close()
+
+	payload := make([]byte, 128)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := conn.WriteFrame(iface.OpBinary, payload); err != nil {
+			b.Fatal(err)
+		}
+		if (i+1)%batch == 0 {
+			if err := conn.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	_ = conn.Flush()
+}
+
+func BenchmarkWriteFrameUnbuffered(b *testing.B) {
+	benchmarkWrite(b, 0, 16)
+}
+
+func BenchmarkWriteFrameBuffered(b *testing.B) {
+	benchmarkWrite(b, iface.DefaultWriteBuffer, 16)
+}
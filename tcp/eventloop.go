@@ -0,0 +1,297 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"im/core"
+	"im/logger"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// readBufSize 事件循环每次从连接读取的最大长度
+const readBufSize = 4096
+
+var (
+	errIdleTimeout = errors.New("idle timeout")
+	errFdReused    = errors.New("fd reused")
+)
+
+// readBufPool 读取缓冲在连接没有未处理的数据时放回，空闲连接不占用缓冲
+var readBufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 0, readBufSize)
+	},
+}
+
+// polledConn 注册在事件循环中的连接
+type polledConn struct {
+	fd      int
+	raw     syscall.RawConn
+	ip      string
+	conn    *TcpConn
+	channel *core.Channel
+	active  int64 //最后一次读取消息帧的时间
+	once    sync.Once
+	// rmu EPOLLONESHOT保证同一时间只有一个worker读取，锁只用于在worker之间同步buf
+	rmu    sync.Mutex
+	buf    []byte //已经读取但还不是完整消息帧的数据
+	reader bytes.Reader
+}
+
+// eventLoop 只从可读的连接中读取消息帧，连接空闲时不占用协程，
+// 空闲超过readwait的连接由sweep关闭
+type eventLoop struct {
+	srv     *Server
+	poller  *poller
+	workers int
+	ready   chan *polledConn
+	quit    *core.Event
+
+	sync.Mutex
+	conns map[int]*polledConn
+}
+
+func newEventLoop(srv *Server, workers int) (*eventLoop, error) {
+	p, err := newPoller()
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &eventLoop{
+		srv:     srv,
+		poller:  p,
+		workers: workers,
+		ready:   make(chan *polledConn, workers*16),
+		quit:    core.NewEvent(),
+		conns:   make(map[int]*polledConn),
+	}, nil
+}
+
+func (l *eventLoop) start() {
+	go l.poll()
+	for i := 0; i < l.workers; i++ {
+		go l.work()
+	}
+	go l.sweep()
+}
+
+// add 把已经登录的连接交给事件循环
+func (l *eventLoop) add(fd int, raw syscall.RawConn, ip string, conn *TcpConn, channel *core.Channel) error {
+	pc := &polledConn{
+		fd:      fd,
+		raw:     raw,
+		ip:      ip,
+		conn:    conn,
+		channel: channel,
+		active:  time.Now().UnixNano(),
+	}
+	l.Lock()
+	old := l.conns[fd]
+	l.Unlock()
+	// fd被复用说明旧连接已经在别处关闭
+	if old != nil {
+		l.close(old, errFdReused)
+	}
+
+	l.Lock()
+	l.conns[fd] = pc
+	l.Unlock()
+	if err := l.poller.add(fd); err != nil {
+		l.close(pc, err)
+		return err
+	}
+	return nil
+}
+
+func (l *eventLoop) poll() {
+	defer func() {
+		close(l.ready)
+		_ = l.poller.close()
+	}()
+	var (
+		fds []int
+		err error
+	)
+	for !l.quit.HasFired() {
+		fds, err = l.poller.wait(fds[:0], 100)
+		if err != nil {
+			logger.WithField("module", "tcp.eventloop").Error(err)
+			return
+		}
+		for _, fd := range fds {
+			l.Lock()
+			pc := l.conns[fd]
+			l.Unlock()
+			if pc != nil {
+				l.ready <- pc
+			}
+		}
+	}
+}
+
+func (l *eventLoop) work() {
+	for pc := range l.ready {
+		if err := l.read(pc); err != nil {
+			l.close(pc, err)
+			continue
+		}
+		if err := l.poller.rearm(pc.fd); err != nil {
+			l.close(pc, err)
+		}
+	}
+}
+
+// read 非阻塞地读取已经到达的数据，只处理其中完整的消息帧，
+// 不完整的部分留在pc.buf中，等待下一次可读，不会阻塞worker
+func (l *eventLoop) read(pc *polledConn) error {
+	pc.rmu.Lock()
+	defer pc.rmu.Unlock()
+	if pc.buf == nil {
+		pc.buf = readBufPool.Get().([]byte)
+	}
+	n, err := readNonblock(pc.raw, pc.buf[len(pc.buf):cap(pc.buf)])
+	if err != nil {
+		return err
+	}
+	pc.buf = pc.buf[:len(pc.buf)+n]
+
+	var (
+		off  int
+		size int
+	)
+	for {
+		size, err = pc.conn.frameSize(pc.buf[off:])
+		if err != nil {
+			return pc.channel.HandleReadError(err)
+		}
+		if size == 0 || size > len(pc.buf)-off {
+			break
+		}
+		pc.reader.Reset(pc.buf[off : off+size])
+		frame, err := pc.conn.readFrame(&pc.reader)
+		if err != nil {
+			return pc.channel.HandleReadError(err)
+		}
+		off += size
+		atomic.StoreInt64(&pc.active, time.Now().UnixNano())
+		if err = pc.channel.HandleFrame(l.srv.MessageListener, frame); err != nil {
+			return err
+		}
+	}
+
+	rest := len(pc.buf) - off
+	switch {
+	case rest == 0:
+		// 只回收默认大小的缓冲，为大消息帧扩容的缓冲直接丢弃
+		if cap(pc.buf) == readBufSize {
+			readBufPool.Put(pc.buf[:0])
+		}
+		pc.buf = nil
+	case size > cap(pc.buf):
+		// 消息帧大于缓冲，按帧的长度扩容，长度已经受maxPayload限制
+		buf := make([]byte, rest, size)
+		copy(buf, pc.buf[off:])
+		if cap(pc.buf) == readBufSize {
+			readBufPool.Put(pc.buf[:0])
+		}
+		pc.buf = buf
+	case off > 0:
+		pc.buf = pc.buf[:copy(pc.buf, pc.buf[off:])]
+	}
+	return nil
+}
+
+// sweep 关闭空闲超过readwait的连接，与Readloop中的读超时对应
+func (l *eventLoop) sweep() {
+	readwait := l.srv.options.readwait
+	interval := readwait / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.quit.Done():
+			return
+		}
+		deadline := time.Now().Add(-readwait).UnixNano()
+		var idle []*polledConn
+		l.Lock()
+		for _, pc := range l.conns {
+			if atomic.LoadInt64(&pc.active) < deadline {
+				idle = append(idle, pc)
+			}
+		}
+		l.Unlock()
+		for _, pc := range idle {
+			l.close(pc, errIdleTimeout)
+		}
+	}
+}
+
+// close 移除并关闭连接，与Readloop退出后的处理相同
+func (l *eventLoop) close(pc *polledConn, reason error) {
+	pc.once.Do(func() {
+		l.Lock()
+		if l.conns[pc.fd] == pc {
+			delete(l.conns, pc.fd)
+			_ = l.poller.remove(pc.fd)
+		}
+		l.Unlock()
+
+		logger.WithFields(logger.Fields{
+			"module": "tcp.eventloop",
+			"id":     pc.channel.ID(),
+		}).Info(reason)
+		_ = pc.conn.Close()
+		l.srv.ChannelMap.Remove(pc.channel.ID())
+		_ = l.srv.StateListener.Disconnect(pc.channel.ID())
+		pc.channel.Close()
+		l.srv.options.limiter.Release(pc.ip)
+	})
+}
+
+// stop 停止事件循环并关闭所有连接
+func (l *eventLoop) stop() {
+	if !l.quit.Fire() {
+		return
+	}
+	l.Lock()
+	conns := make([]*polledConn, 0, len(l.conns))
+	for _, pc := range l.conns {
+		conns = append(conns, pc)
+	}
+	l.Unlock()
+	for _, pc := range conns {
+		l.close(pc, errors.New("server shutdown"))
+	}
+}
+
+// fileDescriptor 只有未经包装的tcp/unix连接才能注册到事件循环，
+// tls等带有用户态缓冲的连接返回false
+func fileDescriptor(conn net.Conn) (syscall.RawConn, int, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, 0, false
+	}
+	fd := -1
+	if err = raw.Control(func(f uintptr) {
+		fd = int(f)
+	}); err != nil || fd < 0 {
+		return nil, 0, false
+	}
+	return raw, fd, true
+}
@@ -0,0 +1,213 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"im/iface"
+	"im/naming"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	p, err := newPoller()
+	assert.Nil(t, err)
+	defer p.close()
+	assert.Nil(t, p.add(fds[0]))
+
+	ready, err := p.wait(nil, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, ready)
+
+	_, err = syscall.Write(fds[1], []byte("a"))
+	assert.Nil(t, err)
+	ready, err = p.wait(ready[:0], 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{fds[0]}, ready)
+
+	// EPOLLONESHOT，重新激活之前不会再次通知
+	ready, err = p.wait(ready[:0], 10)
+	assert.Nil(t, err)
+	assert.Empty(t, ready)
+
+	// 数据没有读取，重新激活后再次通知
+	assert.Nil(t, p.rearm(fds[0]))
+	ready, err = p.wait(ready[:0], 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{fds[0]}, ready)
+
+	assert.Nil(t, p.rearm(fds[0]))
+	assert.Nil(t, p.remove(fds[0]))
+	ready, err = p.wait(ready[:0], 10)
+	assert.Nil(t, err)
+	assert.Empty(t, ready)
+}
+
+func TestReadNonblock(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lis.Close()
+	client, err := net.Dial("tcp", lis.Addr().String())
+	assert.Nil(t, err)
+	server, err := lis.Accept()
+	assert.Nil(t, err)
+	defer server.Close()
+	raw, _, ok := fileDescriptor(server)
+	assert.True(t, ok)
+
+	buf := make([]byte, 16)
+	n, err := readNonblock(raw, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		n, err = readNonblock(raw, buf)
+		return n > 0 || err != nil
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	client.Close()
+	assert.Eventually(t, func() bool {
+		_, err = readNonblock(raw, buf)
+		return err != nil
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, io.EOF, err)
+}
+
+// idAcceptor 以第一个消息帧作为channel id
+type idAcceptor struct{}
+
+func (idAcceptor) Accept(conn iface.IConn, _ time.Duration) (string, error) {
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	return string(frame.GetPayload()), nil
+}
+
+type message struct {
+	id      string
+	payload []byte
+}
+
+type chanListener chan message
+
+func (l chanListener) Receive(ag iface.IAgent, payload []byte) {
+	l <- message{ag.ID(), payload}
+}
+
+func startLoopServer(t *testing.T, workers int) (iface.IServer, chanListener, string) {
+	listen, addr := captureListen()
+	srv := NewServer("127.0.0.1:0", naming.NewEntry("s1", "test", "tcp", "127.0.0.1", 0),
+		WithListenFunc(listen), WithEventLoop(workers), WithMaxPayload(64*1024))
+	lst := make(chanListener, 16)
+	srv.SetAcceptor(idAcceptor{})
+	srv.SetMessageListener(lst)
+	srv.SetStateListener(nopListener{})
+	go func() {
+		_ = srv.Start()
+	}()
+	return srv, lst, (<-addr).String()
+}
+
+func dialLoop(t *testing.T, addr, id string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	_, err = conn.Write(legacy(uint8(iface.OpBinary), []byte(id)))
+	assert.Nil(t, err)
+	return conn
+}
+
+func (l chanListener) expect(t *testing.T, id string, payload []byte) {
+	select {
+	case m := <-l:
+		assert.Equal(t, id, m.id)
+		assert.Equal(t, payload, m.payload)
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait for %s timeout", id)
+	}
+}
+
+// 只有一个worker时，不完整的消息帧不会阻塞其它连接
+func TestEventLoopPartialFrame(t *testing.T) {
+	srv, lst, addr := startLoopServer(t, 1)
+	defer srv.Shutdown(context.Background())
+
+	a := dialLoop(t, addr, "a")
+	defer a.Close()
+	b := dialLoop(t, addr, "b")
+	defer b.Close()
+
+	frame := legacy(uint8(iface.OpBinary), []byte("hello"))
+	_, err := a.Write(frame[:3])
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 50)
+
+	_, err = b.Write(frame)
+	assert.Nil(t, err)
+	lst.expect(t, "b", []byte("hello"))
+
+	_, err = a.Write(frame[3:])
+	assert.Nil(t, err)
+	lst.expect(t, "a", []byte("hello"))
+}
+
+func TestEventLoopFrames(t *testing.T) {
+	srv, lst, addr := startLoopServer(t, 2)
+	defer srv.Shutdown(context.Background())
+
+	conn := dialLoop(t, addr, "a")
+	defer conn.Close()
+
+	// 一次写入多个消息帧，其中一个大于读取缓冲
+	large := bytes.Repeat([]byte("x"), readBufSize*3)
+	var buf bytes.Buffer
+	buf.Write(legacy(uint8(iface.OpBinary), []byte("1")))
+	buf.Write(legacy(uint8(iface.OpBinary), large))
+	buf.Write(legacy(uint8(iface.OpBinary), []byte("2")))
+	_, err := conn.Write(buf.Bytes())
+	assert.Nil(t, err)
+
+	lst.expect(t, "a", []byte("1"))
+	lst.expect(t, "a", large)
+	lst.expect(t, "a", []byte("2"))
+
+	// 逐字节写入
+	for _, c := range legacy(uint8(iface.OpBinary), []byte("3")) {
+		_, err = conn.Write([]byte{c})
+		assert.Nil(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	lst.expect(t, "a", []byte("3"))
+}
+
+// 消息帧超过maxPayload时不等待读取完整，直接关闭连接
+func TestEventLoopFrameTooLarge(t *testing.T) {
+	srv, _, addr := startLoopServer(t, 1)
+	defer srv.Shutdown(context.Background())
+
+	conn := dialLoop(t, addr, "a")
+	defer conn.Close()
+	_, err := conn.Write(append([]byte{uint8(iface.OpBinary)}, length(128*1024)...))
+	assert.Nil(t, err)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	frame, err := NewTcpConn(conn).ReadFrame()
+	assert.Nil(t, err)
+	assert.Equal(t, iface.OpClose, frame.GetOpCode())
+	_, err = NewTcpConn(conn).ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
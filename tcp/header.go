@@ -0,0 +1,116 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"im/iface"
+	"im/wire/endian"
+	"io"
+	"io/ioutil"
+)
+
+// 带版本的帧格式:
+// | magic(2) | version(1) | flags(1) | opcode(1) | length(4) | payload |
+// 旧格式只有 | opcode(1) | length(4) | payload |，由于opcode不会大于0xf，
+// 通过第一个字节是否为Magic0即可区分两种格式
+const (
+	Magic0 uint8 = 0x4b
+	Magic1 uint8 = 0x49
+	// ProtocolVersion 当前支持的最高协议版本
+	ProtocolVersion uint8 = 1
+)
+
+// 能力标识，握手时由双方协商
+const (
+	FlagCompression uint8 = 1 << 0 //支持payload压缩
+	// flagCompressed 当前帧的payload已压缩，不参与协商
+	flagCompressed uint8 = 1 << 7
+)
+
+// SupportedFlags 当前实现支持的能力
+const SupportedFlags = FlagCompression
+
+// DefaultCompressThreshold 小于该长度的payload不压缩
+const DefaultCompressThreshold = 512
+
+// FrameMode 服务端接受的帧格式
+type FrameMode int
+
+const (
+	// ModeAuto 根据第一帧自动识别，兼容旧格式
+	ModeAuto FrameMode = iota
+	// ModeLegacy 只使用旧格式
+	ModeLegacy
+	// ModeVersioned 只接受带版本的格式
+	ModeVersioned
+)
+
+var (
+	ErrLegacyFrame = errors.New("legacy frame is not allowed")
+	// ErrNotNegotiated 帧使用了握手时没有协商的能力
+	ErrNotNegotiated = errors.New("frame uses a capability that is not negotiated")
+	// ErrHandshakeAck 握手之后服务端回复的不是空的消息帧
+	ErrHandshakeAck = errors.New("unexpected handshake ack")
+)
+
+type header struct {
+	version uint8
+	flags   uint8
+	opcode  uint8
+	length  uint32
+}
+
+// readHeader 读取magic之后的部分，buf至少为8字节
+func readHeader(r io.Reader, buf []byte) (h header, err error) {
+	if _, err = io.ReadFull(r, buf[:8]); err != nil {
+		return
+	}
+	if buf[0] != Magic1 {
+		err = fmt.Errorf("bad magic: %#x %#x", Magic0, buf[0])
+		return
+	}
+	h.version, h.flags, h.opcode = buf[1], buf[2], buf[3]
+	h.length = endian.Default.Uint32(buf[4:8])
+	return
+}
+
+func writeHeader(w io.Writer, h header) error {
+	if _, err := w.Write([]byte{Magic0, Magic1, h.version, h.flags, h.opcode}); err != nil {
+		return err
+	}
+	return endian.WriteUint32(w, h.length)
+}
+
+func compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(p); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress 解压后的长度同样受maxPayload限制
+func decompress(p []byte, maxPayload int) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(p))
+	defer fr.Close()
+	if maxPayload <= 0 {
+		return ioutil.ReadAll(fr)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(fr, int64(maxPayload)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxPayload {
+		return nil, &iface.FrameTooLargeError{Size: uint64(len(buf)), Max: maxPayload}
+	}
+	return buf, nil
+}
@@ -0,0 +1,246 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"im/iface"
+	"im/wire/endian"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bufConn 从buf读取，写入到out
+type bufConn struct {
+	net.Conn
+	buf *bytes.Buffer
+	out bytes.Buffer
+}
+
+func newBufConn(data []byte) *bufConn {
+	return &bufConn{buf: bytes.NewBuffer(data)}
+}
+
+func (c *bufConn) Read(b []byte) (int, error)      { return c.buf.Read(b) }
+func (c *bufConn) Write(b []byte) (int, error)     { return c.out.Write(b) }
+func (c *bufConn) SetReadDeadline(time.Time) error { return nil }
+
+func versioned(version, flags, opcode uint8, payload []byte) []byte {
+	var buf bytes.Buffer
+	_ = writeHeader(&buf, header{version: version, flags: flags, opcode: opcode, length: uint32(len(payload))})
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func legacy(opcode uint8, payload []byte) []byte {
+	var buf bytes.Buffer
+	_ = WriteFrame(&buf, iface.OpCode(opcode), payload)
+	return buf.Bytes()
+}
+
+func length(n uint32) []byte {
+	b := make([]byte, 4)
+	endian.Default.PutUint32(b, n)
+	return b
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want header
+		err  bool
+	}{
+		{"ok", append([]byte{Magic1, 1, FlagCompression, 2}, length(5)...), header{version: 1, flags: FlagCompression, opcode: 2, length: 5}, false},
+		{"bad magic", append([]byte{0x00, 1, 0, 2}, length(5)...), header{}, true},
+		{"truncated", []byte{Magic1, 1, 0}, header{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf [8]byte
+			h, err := readHeader(bytes.NewReader(tt.data), buf[:])
+			if tt.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, h)
+		})
+	}
+}
+
+func TestReadFrameFormat(t *testing.T) {
+	payload := []byte("hello")
+	tests := []struct {
+		name    string
+		mode    FrameMode
+		data    []byte
+		version uint8
+		err     error
+	}{
+		{"auto legacy", ModeAuto, legacy(uint8(iface.OpBinary), payload), 0, nil},
+		{"auto versioned", ModeAuto, versioned(1, 0, uint8(iface.OpBinary), payload), 1, nil},
+		{"legacy only", ModeLegacy, legacy(uint8(iface.OpBinary), payload), 0, nil},
+		{"versioned only", ModeVersioned, versioned(1, 0, uint8(iface.OpBinary), payload), 1, nil},
+		{"versioned rejects legacy", ModeVersioned, legacy(uint8(iface.OpBinary), payload), 0, ErrLegacyFrame},
+		{"newer version", ModeAuto, versioned(ProtocolVersion+1, 0, uint8(iface.OpBinary), payload), ProtocolVersion, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewServerConn(newBufConn(tt.data), tt.mode, SupportedFlags, 0)
+			frame, err := conn.ReadFrame()
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, iface.OpBinary, frame.GetOpCode())
+			assert.Equal(t, payload, frame.GetPayload())
+			assert.Equal(t, tt.version, conn.Version())
+		})
+	}
+}
+
+// 自动识别后格式固定，之后的帧不能切换格式
+func TestReadFrameFormatFixed(t *testing.T) {
+	data := append(versioned(1, 0, uint8(iface.OpBinary), []byte("a")), legacy(uint8(iface.OpBinary), []byte("b"))...)
+	conn := NewServerConn(newBufConn(data), ModeAuto, SupportedFlags, 0)
+	_, err := conn.ReadFrame()
+	assert.Nil(t, err)
+	_, err = conn.ReadFrame()
+	assert.Equal(t, ErrLegacyFrame, err)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		offer  uint8
+		client uint8
+		want   uint8
+	}{
+		{"both", FlagCompression, FlagCompression, FlagCompression},
+		{"server only", FlagCompression, 0, 0},
+		{"client only", 0, FlagCompression, 0},
+		{"unknown flags", FlagCompression, FlagCompression | 1<<3, FlagCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewServerConn(newBufConn(versioned(1, tt.client, uint8(iface.OpPing), nil)), ModeAuto, tt.offer, 0)
+			_, err := conn.ReadFrame()
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, conn.Flags())
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte("hello"), 200)
+
+	// 协商压缩之后，大于阈值的payload压缩发送
+	raw := newBufConn(versioned(1, FlagCompression, uint8(iface.OpPing), nil))
+	server := NewServerConn(raw, ModeAuto, FlagCompression, 0)
+	_, err := server.ReadFrame()
+	assert.Nil(t, err)
+	assert.Nil(t, server.WriteFrame(iface.OpBinary, payload))
+	assert.Less(t, raw.out.Len(), len(payload))
+
+	client := NewVersionedConn(newBufConn(raw.out.Bytes()), FlagCompression)
+	frame, err := client.ReadFrame()
+	assert.Nil(t, err)
+	assert.Equal(t, payload, frame.GetPayload())
+	assert.Equal(t, FlagCompression, client.Flags())
+}
+
+func TestCompressionNotNegotiated(t *testing.T) {
+	compressed, err := compress([]byte("hello"))
+	assert.Nil(t, err)
+	tests := []struct {
+		name  string
+		offer uint8
+		flags uint8
+	}{
+		{"server does not offer", 0, FlagCompression | flagCompressed},
+		{"client does not offer", FlagCompression, flagCompressed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewServerConn(newBufConn(versioned(1, tt.flags, uint8(iface.OpBinary), compressed)), ModeAuto, tt.offer, 0)
+			_, err := conn.ReadFrame()
+			assert.Equal(t, ErrNotNegotiated, err)
+		})
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	compressed, err := compress(make([]byte, 1024*1024))
+	assert.Nil(t, err)
+
+	data := append(versioned(1, FlagCompression, uint8(iface.OpPing), nil),
+		versioned(1, FlagCompression|flagCompressed, uint8(iface.OpBinary), compressed)...)
+	conn := NewServerConn(newBufConn(data), ModeAuto, FlagCompression, 4096)
+	_, err = conn.ReadFrame()
+	assert.Nil(t, err)
+	_, err = conn.ReadFrame()
+	var tooLarge *iface.FrameTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	_ = endian.WriteUint8(&buf, uint8(iface.OpBinary))
+	_ = endian.WriteUint32(&buf, 1024)
+	conn := NewTcpConnWithLimit(newBufConn(buf.Bytes()), 16)
+	_, err := conn.ReadFrame()
+	var tooLarge *iface.FrameTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, uint64(1024), tooLarge.Size)
+}
+
+// 握手时服务端回复协商后的能力，客户端在第一个消息之前就可以使用
+func TestHandshakeAck(t *testing.T) {
+	tests := []struct {
+		name   string
+		server uint8
+		client uint8
+		want   uint8
+	}{
+		{"both", FlagCompression, FlagCompression, FlagCompression},
+		{"server only", FlagCompression, 0, 0},
+		{"client only", 0, FlagCompression, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+			server := NewServerConn(a, ModeAuto, tt.server, 0)
+			client := NewVersionedConn(b, tt.client)
+			go func() {
+				_ = client.WriteFrame(iface.OpBinary, []byte("handshake"))
+			}()
+			_, err := server.ReadFrame()
+			assert.Nil(t, err)
+			go func() {
+				_ = server.AckHandshake()
+			}()
+			assert.Nil(t, client.WaitHandshakeAck(time.Second))
+			assert.Equal(t, tt.want, client.Flags())
+			assert.Equal(t, tt.want, server.Flags())
+		})
+	}
+}
+
+// 旧格式的对端不等待回复
+func TestHandshakeAckLegacy(t *testing.T) {
+	raw := newBufConn(legacy(uint8(iface.OpBinary), []byte("handshake")))
+	server := NewServerConn(raw, ModeAuto, FlagCompression, 0)
+	_, err := server.ReadFrame()
+	assert.Nil(t, err)
+	assert.Nil(t, server.AckHandshake())
+	assert.Equal(t, 0, raw.out.Len())
+
+	client := NewVersionedConn(newBufConn(versioned(1, 0, uint8(iface.OpBinary), []byte("data"))), 0)
+	assert.Equal(t, ErrHandshakeAck, client.WaitHandshakeAck(time.Second))
+}
@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+package tcp
+
+import (
+	"io"
+	"syscall"
+)
+
+// poller 基于epoll，每个fd以EPOLLONESHOT注册，处理完一个帧后重新激活，
+// 保证同一时间只有一个协程读取同一个连接
+type poller struct {
+	fd     int
+	events []syscall.EpollEvent
+}
+
+func newPoller() (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{fd: fd, events: make([]syscall.EpollEvent, 128)}, nil
+}
+
+const pollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+func (p *poller) add(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Events: pollEvents, Fd: int32(fd)})
+}
+
+func (p *poller) rearm(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Events: pollEvents, Fd: int32(fd)})
+}
+
+func (p *poller) remove(fd int) error {
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait 等待最多msec毫秒，把可读的fd追加到fds，只能在一个协程中调用
+func (p *poller) wait(fds []int, msec int) ([]int, error) {
+	for {
+		n, err := syscall.EpollWait(p.fd, p.events, msec)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fds, err
+		}
+		for i := 0; i < n; i++ {
+			fds = append(fds, int(p.events[i].Fd))
+		}
+		return fds, nil
+	}
+}
+
+func (p *poller) close() error {
+	return syscall.Close(p.fd)
+}
+
+// readNonblock 读取连接中已经到达的数据，没有数据时返回0, nil。
+// 通过RawConn读取，读取期间连接不会被关闭，fd不会被复用
+func readNonblock(raw syscall.RawConn, p []byte) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	if cerr := raw.Read(func(fd uintptr) bool {
+		n, err = syscall.Read(int(fd), p)
+		// 返回true，没有数据时不等待
+		return true
+	}); cerr != nil {
+		return 0, cerr
+	}
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package tcp
+
+import (
+	"errors"
+	"syscall"
+)
+
+type poller struct{}
+
+func newPoller() (*poller, error) {
+	return nil, errors.New("event loop mode is only supported on linux")
+}
+
+func (p *poller) add(fd int) error                            { return nil }
+func (p *poller) rearm(fd int) error                          { return nil }
+func (p *poller) remove(fd int) error                         { return nil }
+func (p *poller) wait(fds []int, msec int) ([]int, error)     { return fds, nil }
+func (p *poller) close() error                                { return nil }
+func readNonblock(raw syscall.RawConn, p []byte) (int, error) { return 0, nil }
@@ -0,0 +1,206 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"im/core"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol，见 https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+var (
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader = errors.New("proxy protocol: bad header")
+)
+
+const (
+	proxyV1MaxLen = 107
+	// DefaultProxyHeaderTimeout 读取PROXY头的超时时间
+	DefaultProxyHeaderTimeout = time.Second * 5
+)
+
+// proxyListener 在tls之前包装监听，来自受信任地址的连接必须以PROXY头开始
+type proxyListener struct {
+	net.Listener
+	trusted core.TrustedProxies
+	timeout time.Duration
+}
+
+// newProxyListener trusted为空时信任所有来源
+func newProxyListener(lis net.Listener, trusted core.TrustedProxies, timeout time.Duration) net.Listener {
+	return &proxyListener{
+		Listener: lis,
+		trusted:  trusted,
+		timeout:  timeout,
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.trusted) > 0 && !l.trusted.Contains(core.RemoteIP(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+// proxyConn 在第一次Read或RemoteAddr时读取PROXY头，避免阻塞Accept循环
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+	once    sync.Once
+	reader  *bufio.Reader
+	remote  net.Addr
+	err     error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer func() {
+				_ = c.Conn.SetReadDeadline(time.Time{})
+			}()
+		}
+		c.remote, c.err = readProxyHeader(c.reader)
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// peerAddr 返回直连的对端地址，不会读取PROXY头
+func peerAddr(conn net.Conn) net.Addr {
+	if c, ok := conn.(*proxyConn); ok {
+		return c.Conn.RemoteAddr()
+	}
+	return conn.RemoteAddr()
+}
+
+// RemoteAddr 返回PROXY头中的源地址，头部为LOCAL或UNKNOWN时返回对端地址
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader 读取v1或v2格式的PROXY头，返回nil地址表示使用对端地址
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	sig, err := r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+	return nil, errProxyHeader
+}
+
+// readProxyV1 PROXY TCP4 1.1.1.1 2.2.2.2 51000 8000\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 {
+		return nil, errProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("proxy protocol: unknown protocol %s", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errProxyHeader
+	}
+	port, err := strconv.Atoi(fields[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 | sig(12) | ver_cmd(1) | fam(1) | len(2) | addresses | tlvs |
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("proxy protocol: unsupported version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	// LOCAL，由代理自身发起的连接(如健康检查)
+	if hdr[12]&0xf == 0 {
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+	// AF_UNSPEC或AF_UNIX
+	return nil, nil
+}
@@ -0,0 +1,92 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// proxyV2 构造v2头，cmd为0(LOCAL)或1(PROXY)，fam为地址族
+func proxyV2(cmd, fam byte, addrs []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Sig)
+	buf.WriteByte(0x20 | cmd)
+	buf.WriteByte(fam<<4 | 1)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+// inetAddrs src/dst地址与端口
+func inetAddrs(src, dst net.IP, sport, dport uint16) []byte {
+	var buf bytes.Buffer
+	buf.Write(src)
+	buf.Write(dst)
+	_ = binary.Write(&buf, binary.BigEndian, sport)
+	_ = binary.Write(&buf, binary.BigEndian, dport)
+	return buf.Bytes()
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	inet := proxyV2(1, 1, inetAddrs(net.ParseIP("1.1.1.1").To4(), net.ParseIP("2.2.2.2").To4(), 51000, 8000))
+	inet6 := proxyV2(1, 2, inetAddrs(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 51000, 8000))
+	tests := []struct {
+		name string
+		data []byte
+		want string // 空表示使用对端地址
+		err  bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 1.1.1.1 2.2.2.2 51000 8000\r\n"), "1.1.1.1:51000", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 51000 8000\r\n"), "[2001:db8::1]:51000", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 unknown protocol", []byte("PROXY UDP4 1.1.1.1 2.2.2.2 51000 8000\r\n"), "", true},
+		{"v1 bad ip", []byte("PROXY TCP4 1.1.1 2.2.2.2 51000 8000\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 1.1.1.1 2.2.2.2 70000 8000\r\n"), "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 1.1.1.1 2.2.2.2\r\n"), "", true},
+		{"v1 without crlf", []byte("PROXY TCP4 1.1.1.1 2.2.2.2 51000 8000\n"), "", true},
+		{"v1 truncated", []byte("PROXY TCP4 1.1.1.1"), "", true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", proxyV1MaxLen) + "\r\n"), "", true},
+		{"v2 local", proxyV2(0, 0, nil), "", false},
+		{"v2 inet", inet, "1.1.1.1:51000", false},
+		{"v2 inet6", inet6, "[2001:db8::1]:51000", false},
+		{"v2 unspec", proxyV2(1, 0, nil), "", false},
+		{"v2 short inet", proxyV2(1, 1, make([]byte, 8)), "", true},
+		{"v2 short inet6", proxyV2(1, 2, make([]byte, 12)), "", true},
+		{"v2 truncated header", inet[:14], "", true},
+		{"v2 truncated addresses", inet[:len(inet)-4], "", true},
+		{"v2 bad version", append(append([]byte{}, proxyV2Sig...), 0x11, 0x11, 0, 0), "", true},
+		{"no header", []byte("GET / HTTP/1.1\r\n"), "", true},
+		{"truncated", []byte("PRO"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := readProxyHeader(bufio.NewReader(bytes.NewReader(tt.data)))
+			if tt.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			if tt.want == "" {
+				assert.Nil(t, addr)
+				return
+			}
+			assert.Equal(t, tt.want, addr.String())
+		})
+	}
+}
+
+// 读取PROXY头之后，剩余的数据可以正常读取
+func TestProxyConnRead(t *testing.T) {
+	data := append([]byte("PROXY TCP4 1.1.1.1 2.2.2.2 51000 8000\r\n"), "hello"...)
+	conn := &proxyConn{Conn: newBufConn(data)}
+	assert.Equal(t, "1.1.1.1:51000", conn.RemoteAddr().String())
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+}
@@ -2,12 +2,13 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
 	"im/core"
 	"im/iface"
 	"im/logger"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -15,9 +16,90 @@ import (
 )
 
 type ServerOption struct {
-	loginwait time.Duration
-	readwait  time.Duration
-	writewait time.Duration
+	loginwait  time.Duration
+	readwait   time.Duration
+	writewait  time.Duration
+	tlsConfig  *tls.Config
+	maxPayload int
+	frameMode  FrameMode
+	flags      uint8
+	listen     func(network, address string) (net.Listener, error)
+	limiter    *core.ConnLimiter
+	proxy      bool
+	trusted    core.TrustedProxies
+	eventLoop  bool
+	workers    int
+	writeBuf   int
+}
+
+type Option func(opts *ServerOption)
+
+// WithMaxPayload 设置消息帧的最大长度
+func WithMaxPayload(size int) Option {
+	return func(opts *ServerOption) {
+		opts.maxPayload = size
+	}
+}
+
+// WithFrameMode 设置接受的帧格式，默认自动识别并兼容旧格式
+func WithFrameMode(mode FrameMode) Option {
+	return func(opts *ServerOption) {
+		opts.frameMode = mode
+	}
+}
+
+// WithFlags 设置握手时可以协商的能力，默认为SupportedFlags
+func WithFlags(flags uint8) Option {
+	return func(opts *ServerOption) {
+		opts.flags = flags
+	}
+}
+
+// WithListenFunc 替换net.Listen，可用于内存中的监听
+func WithListenFunc(listen func(network, address string) (net.Listener, error)) Option {
+	return func(opts *ServerOption) {
+		opts.listen = listen
+	}
+}
+
+// WithConnLimiter 设置连接数限制，超过限制的连接在读取登录包之前被关闭
+func WithConnLimiter(limiter *core.ConnLimiter) Option {
+	return func(opts *ServerOption) {
+		opts.limiter = limiter
+	}
+}
+
+// WithProxyProtocol 解析负载均衡发送的PROXY protocol(v1/v2)头，
+// 来自trusted的连接必须带有PROXY头，trusted为空时所有连接都必须带有
+func WithProxyProtocol(trusted core.TrustedProxies) Option {
+	return func(opts *ServerOption) {
+		opts.proxy = true
+		opts.trusted = trusted
+	}
+}
+
+// WithEventLoop 开启事件循环模式(仅linux)，连接空闲时不占用协程，
+// 由workers个协程读取可读连接上的消息帧，workers为0时使用cpu核数。
+// tls与PROXY protocol的连接带有用户态缓冲，仍然使用Readloop
+func WithEventLoop(workers int) Option {
+	return func(opts *ServerOption) {
+		opts.eventLoop = true
+		opts.workers = workers
+	}
+}
+
+// WithWriteBuffer 设置连接的写缓冲大小，为0时每个消息帧直接写入
+func WithWriteBuffer(size int) Option {
+	return func(opts *ServerOption) {
+		opts.writeBuf = size
+	}
+}
+
+// WithTLSConfig 开启tls监听
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(opts *ServerOption) {
+		opts.tlsConfig = cfg
+	}
 }
 
 // tcp server
@@ -31,20 +113,40 @@ type Server struct {
 	once            sync.Once
 	options         ServerOption
 	quit            iface.IEvent
+	loop            *eventLoop
+	lis             net.Listener
+	lmu             sync.Mutex
 }
 
-func NewServer(addr string, service iface.ServiceRegistration) iface.IServer {
-	return &Server{
+func NewServer(addr string, service iface.ServiceRegistration, opts ...Option) iface.IServer {
+	srv := &Server{
 		listen:              addr,
 		ServiceRegistration: service,
 		ChannelMap:          core.NewChannels(100),
 		quit:                core.NewEvent(),
 		options: ServerOption{
-			loginwait: iface.DefaultLoginWait,
-			readwait:  iface.DefaultReadWait,
-			writewait: iface.DefaultWriteWait,
+			loginwait:  iface.DefaultLoginWait,
+			readwait:   iface.DefaultReadWait,
+			writewait:  iface.DefaultWriteWait,
+			maxPayload: iface.DefaultMaxPayload,
+			writeBuf:   iface.DefaultWriteBuffer,
+			frameMode:  ModeAuto,
+			flags:      SupportedFlags,
+			listen:     net.Listen,
 		},
 	}
+	for _, opt := range opts {
+		opt(&srv.options)
+	}
+	if srv.options.limiter == nil {
+		srv.options.limiter = core.NewConnLimiter("tcp", core.ConnLimits{})
+	}
+	return srv
+}
+
+// ConnStats 返回当前的连接计数
+func (srv *Server) ConnStats() core.ConnStats {
+	return srv.options.limiter.Stats()
 }
 
 // 启动服务
@@ -63,35 +165,98 @@ func (srv *Server) Start() error {
 		srv.Acceptor = new(defaultAcceptor)
 	}
 
-	lis, err := net.Listen("tcp", srv.listen)
+	network, address := ParseAddress(srv.listen)
+	if network == "unix" {
+		// 清理上次进程退出时遗留的socket文件，路径上是其它文件时不删除，由Listen返回错误
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(address)
+		}
+	}
+	lis, err := srv.options.listen(network, address)
 	if err != nil {
 		return err
 	}
+	if srv.options.proxy {
+		lis = newProxyListener(lis, srv.options.trusted, DefaultProxyHeaderTimeout)
+	}
+	if srv.options.tlsConfig != nil {
+		lis = tls.NewListener(lis, srv.options.tlsConfig)
+	}
+	srv.lmu.Lock()
+	if srv.quit.HasFired() {
+		srv.lmu.Unlock()
+		_ = lis.Close()
+		return nil
+	}
+	srv.lis = lis
+	srv.lmu.Unlock()
+
+	if srv.options.eventLoop {
+		if srv.loop, err = newEventLoop(srv, srv.options.workers); err != nil {
+			return err
+		}
+		srv.loop.start()
+	}
 
 	log.Infof("tcp server started on port:%s\n", srv.listen)
 	for {
 		rawconn, err := lis.Accept()
 		if err != nil {
-			rawconn.Close()
+			// Shutdown关闭了监听
+			if srv.quit.HasFired() {
+				log.Info("listener closed")
+				return nil
+			}
 			log.Warn(err)
 			continue
 		}
 
 		go func(rawconn net.Conn) {
-			conn := NewTcpConn(rawconn)
+			// 开启PROXY protocol时读取客户端ip需要等待PROXY头，先占用握手名额
+			if err := srv.options.limiter.AcquireHandshake(); err != nil {
+				log.Warnf("reject %s: %v", peerAddr(rawconn), err)
+				rawconn.Close()
+				return
+			}
+			ip := core.RemoteIP(rawconn.RemoteAddr())
+			if err := srv.options.limiter.AcquireConn(ip); err != nil {
+				srv.options.limiter.HandshakeDone()
+				log.Warnf("reject %s: %v", ip, err)
+				rawconn.Close()
+				return
+			}
+			conn := NewServerConn(rawconn, srv.options.frameMode, srv.options.flags, srv.options.maxPayload)
+			conn.SetWriteBuffer(srv.options.writeBuf)
 			id, err := srv.Acceptor.Accept(conn, srv.options.loginwait)
+			srv.options.limiter.HandshakeDone()
 			if err != nil {
 				_ = conn.WriteFrame(iface.OpClose, []byte(err.Error()))
 				conn.Close()
+				srv.options.limiter.Release(ip)
 				return
 			}
 
 			if _, ok := srv.ChannelMap.Get(id); ok {
 				_ = conn.WriteFrame(iface.OpClose, []byte("channel id is connected"))
 				conn.Close()
+				srv.options.limiter.Release(ip)
 				return
 			}
 
+			if srv.loop != nil {
+				if raw, fd, ok := fileDescriptor(rawconn); ok {
+					channel := core.NewPolledChannel(id, conn)
+					channel.SetWriteWait(srv.options.writewait)
+
+					srv.ChannelMap.Add(channel)
+					log.Info("accept ", channel.ID())
+					if err = srv.loop.add(fd, raw, ip, conn, channel); err != nil {
+						log.Warn(err)
+					}
+					return
+				}
+			}
+
 			channel := core.NewChannel(id, conn)
 			channel.SetReadWait(srv.options.readwait)
 			channel.SetWriteWait(srv.options.writewait)
@@ -105,22 +270,16 @@ func (srv *Server) Start() error {
 
 			_ = srv.StateListener.Disconnect(channel.ID())
 			channel.Close()
+			srv.options.limiter.Release(ip)
 		}(rawconn)
-
-		select {
-		case <-srv.quit.Done():
-			return fmt.Errorf("listen exited")
-		default:
-		}
 	}
-	return nil
 }
 
 // 根据id给连接发送消息
 func (srv *Server) Push(id string, payload []byte) error {
 	channel, ok := srv.ChannelMap.Get(id)
 	if !ok {
-		return errors.New("channel:" + channel.ID() + " not found")
+		return errors.New("channel:" + id + " not found")
 	}
 	return channel.Push(payload)
 }
@@ -136,6 +295,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		defer func() {
 			log.Infoln("shutdown")
 		}()
+		// 关闭监听，unix socket与pipe的地址随之释放
+		s.lmu.Lock()
+		s.quit.Fire()
+		if s.lis != nil {
+			_ = s.lis.Close()
+		}
+		s.lmu.Unlock()
+		if s.loop != nil {
+			s.loop.stop()
+		}
 
 		channels := s.ChannelMap.All()
 		for _, channel := range channels {
@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"context"
+	"im/core"
+	"im/iface"
+	"im/naming"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopListener struct{}
+
+func (nopListener) Receive(iface.IAgent, []byte) {}
+
+func (nopListener) Disconnect(string) error { return nil }
+
+// captureListen 返回监听的地址，用于监听127.0.0.1:0
+func captureListen() (func(network, address string) (net.Listener, error), chan net.Addr) {
+	addr := make(chan net.Addr, 1)
+	return func(network, address string) (net.Listener, error) {
+		lis, err := net.Listen(network, address)
+		if err == nil {
+			addr <- lis.Addr()
+		}
+		return lis, err
+	}, addr
+}
+
+// 配置的路径上不是socket文件时不能被删除
+func TestStartUnixKeepsRegularFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("data"), 0644))
+
+	srv := NewServer("unix://"+path, naming.NewEntry("s1", "test", naming.ProtocolUnix, path, 0))
+	srv.SetStateListener(nopListener{})
+	assert.NotNil(t, srv.Start())
+
+	buf, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(buf))
+}
+
+// 开启PROXY protocol时，等待PROXY头的连接占用握手名额
+func TestProxyHandshakeLimit(t *testing.T) {
+	listen, addr := captureListen()
+	limiter := core.NewConnLimiter("tcp", core.ConnLimits{MaxHandshakes: 1})
+	trusted, err := core.ParseTrustedProxies([]string{"127.0.0.1"})
+	assert.Nil(t, err)
+	srv := NewServer("127.0.0.1:0", naming.NewEntry("s1", "test", "tcp", "127.0.0.1", 0),
+		WithListenFunc(listen), WithProxyProtocol(trusted), WithConnLimiter(limiter))
+	srv.SetStateListener(nopListener{})
+	go func() {
+		_ = srv.Start()
+	}()
+	defer srv.Shutdown(context.Background())
+	local := <-addr
+
+	// 不发送PROXY头
+	pending, err := net.Dial("tcp", local.String())
+	assert.Nil(t, err)
+	defer pending.Close()
+	assert.Eventually(t, func() bool {
+		return limiter.Stats().Handshakes == 1
+	}, time.Second, time.Millisecond*10)
+
+	rejected, err := net.Dial("tcp", local.String())
+	assert.Nil(t, err)
+	defer rejected.Close()
+	_ = rejected.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = rejected.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, core.ConnStats{Conns: 0, Handshakes: 1, Rejected: 1}, limiter.Stats())
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// TokenQuery url中携带token的参数名
+	TokenQuery = "token"
+	// TokenProtocol 子协议中携带token时的协议名，格式为 Sec-WebSocket-Protocol: access_token, <token>
+	TokenProtocol = "access_token"
+)
+
+// AuthFunc 在websocket升级之前鉴权，返回非nil的error时以status拒绝升级。
+// identity为鉴权得到的账号，保存在连接上，登录时用于校验登录包中的账号
+type AuthFunc func(r *http.Request, token string) (identity string, status int, err error)
+
+// TokenFromRequest 依次从query、Authorization头、子协议中读取token
+func TokenFromRequest(r *http.Request) string {
+	if tk := r.URL.Query().Get(TokenQuery); tk != "" {
+		return tk
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	protocols := subprotocols(r)
+	for i, p := range protocols {
+		if p == TokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+func subprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+func checkOrigin(r *http.Request, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	// 非浏览器客户端不会带Origin
+	if origin == "" {
+		return true
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
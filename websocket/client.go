@@ -1,8 +1,10 @@
 package websocket
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"im/core"
 	"im/iface"
 	"im/logger"
 	"net"
@@ -20,6 +22,22 @@ type ClientOptions struct {
 	Heartbeat time.Duration //登陆超时
 	ReadWait  time.Duration //读超时
 	WriteWait time.Duration //写超时
+	TLSConfig *tls.Config   //wss连接使用的tls配置
+	//是否协商permessage-deflate，CompressThreshold为压缩阈值
+	Compression       bool
+	CompressThreshold int
+	//消息帧最大长度，为0时使用iface.DefaultMaxPayload
+	MaxPayload int
+	//心跳连续MaxMissedPongs次没有收到pong时关闭连接，为0时使用iface.DefaultMaxMissedPongs
+	MaxMissedPongs int
+	//断线后自动重新拨号并握手，重试间隔在ReconnectMin与ReconnectMax之间指数增长，
+	//MaxRetries为0时不限制重试次数
+	Reconnect    bool
+	ReconnectMin time.Duration
+	ReconnectMax time.Duration
+	MaxRetries   int
+	//连接状态变化时回调，err为断开的原因
+	OnStateChange func(state iface.ConnState, err error)
 }
 
 // Client is a websocket implement of the terminal
@@ -32,7 +50,10 @@ type Client struct {
 	conn    net.Conn
 	state   int32
 	options ClientOptions
+	flate   bool
 	dc      *iface.DialerContext
+	closed  *core.Event
+	pings   core.PingTracker
 	Meta    map[string]string
 }
 
@@ -44,11 +65,21 @@ func NewClient(id, name string, opts ClientOptions) iface.IClient {
 	if opts.ReadWait == 0 {
 		opts.ReadWait = iface.DefaultReadWait
 	}
+	if opts.CompressThreshold == 0 {
+		opts.CompressThreshold = DefaultCompressThreshold
+	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = iface.DefaultMaxPayload
+	}
+	if opts.MaxMissedPongs == 0 {
+		opts.MaxMissedPongs = iface.DefaultMaxMissedPongs
+	}
 
 	cli := &Client{
 		id:      id,
 		name:    name,
 		options: opts,
+		closed:  core.NewEvent(),
 		Meta:    make(map[string]string),
 	}
 	return cli
@@ -61,11 +92,21 @@ func NewClientWithProps(id, name string, meta map[string]string, opts ClientOpti
 	if opts.ReadWait == 0 {
 		opts.ReadWait = iface.DefaultReadWait
 	}
+	if opts.CompressThreshold == 0 {
+		opts.CompressThreshold = DefaultCompressThreshold
+	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = iface.DefaultMaxPayload
+	}
+	if opts.MaxMissedPongs == 0 {
+		opts.MaxMissedPongs = iface.DefaultMaxMissedPongs
+	}
 
 	cli := &Client{
 		id:      id,
 		name:    name,
 		options: opts,
+		closed:  core.NewEvent(),
 		Meta:    meta,
 	}
 	return cli
@@ -84,81 +125,226 @@ func (c *Client) GetMeta() map[string]string {
 }
 
 func (c *Client) Connect(addr string) error {
-	_, err := url.Parse(addr)
+	u, err := url.Parse(addr)
 	if err != nil {
 		return err
 	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	tlsConfig := c.options.TLSConfig
+	if u.Scheme == "wss" && tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
 
-	if !atomic.CompareAndSwapInt32(&c.state, 0, 1) {
+	if !atomic.CompareAndSwapInt32(&c.state, int32(iface.ConnDisconnected), int32(iface.ConnConnected)) {
 		return fmt.Errorf("client has connected")
 	}
 
+	c.dc = &iface.DialerContext{
+		Id:          c.id,
+		Name:        c.name,
+		Address:     addr,
+		Timeout:     iface.DefaultLoginWait,
+		TLSConfig:   tlsConfig,
+		Compression: c.options.Compression,
+	}
 	//拨号上网
-	conn, err := c.DialAndHandshake(iface.DialerContext{
-		Id:      c.id,
-		Name:    c.name,
-		Address: addr,
-		Timeout: iface.DefaultLoginWait,
-	})
+	conn, err := c.dial()
 	if err != nil {
-		atomic.CompareAndSwapInt32(&c.state, 1, 0)
+		atomic.CompareAndSwapInt32(&c.state, int32(iface.ConnConnected), int32(iface.ConnDisconnected))
 		return err
 	}
+	c.Lock()
+	c.conn = conn
+	c.flate = isCompressed(conn)
+	c.Unlock()
+	c.startHeartbeat(conn)
+	c.notify(iface.ConnConnected, nil)
+	return nil
+}
+
+// dial 通过IDialer拨号并完成握手
+func (c *Client) dial() (net.Conn, error) {
+	conn, err := c.DialAndHandshake(*c.dc)
+	if err != nil {
+		return nil, err
+	}
 	if conn == nil {
-		return errors.New("conn is nil")
+		return nil, errors.New("conn is nil")
 	}
-	c.conn = conn
+	return conn, nil
+}
+
+func (c *Client) startHeartbeat(conn net.Conn) {
+	c.pings.Reset()
 	if c.options.Heartbeat > 0 {
 		go func() {
-			err := c.heartloop(c.conn)
+			err := c.heartloop(conn)
 			if err != nil {
 				logger.Error("heartbealoop stopped ", err)
 			}
 		}()
 	}
-	return nil
 }
 
+// Read 开启Reconnect时，连接断开后会重连并继续读取，直到重连失败或调用了Close
 func (c *Client) Read() (iface.IFrame, error) {
-	if c.conn == nil {
-		return nil, errors.New("conn is nil ")
-	}
+	for {
+		c.Lock()
+		conn, flate := c.conn, c.flate
+		c.Unlock()
+		if conn == nil {
+			return nil, errors.New("conn is nil ")
+		}
+
+		if c.options.ReadWait > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+		}
 
-	if c.options.ReadWait > 0 {
-		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+		frame, err := readFrame(conn, c.options.MaxPayload)
+		if err == nil {
+			if frame.Header.OpCode == ws.OpPong {
+				c.pings.Pong()
+			}
+			if frame.Header.OpCode == ws.OpClose {
+				return nil, errors.New("the connection is closed")
+			}
+			if flate {
+				if frame, err = decompressFrame(frame, c.options.MaxPayload); err != nil {
+					c.closeIfTooLarge(err)
+					return nil, err
+				}
+			}
+			return &Frame{raw: frame}, nil
+		}
+		if c.closeIfTooLarge(err) {
+			return nil, err
+		}
+		if !c.options.Reconnect || c.closed.HasFired() {
+			return nil, err
+		}
+		if err = c.reconnect(conn, err); err != nil {
+			return nil, err
+		}
 	}
+}
 
-	frame, err := ws.ReadFrame(c.conn)
-	if err != nil {
-		return nil, err
+// reconnect 关闭断开的连接，按退避间隔重新拨号
+func (c *Client) reconnect(old net.Conn, cause error) error {
+	log := logger.WithFields(logger.Fields{
+		"module": "ws.client",
+		"id":     c.id,
+	})
+	_ = old.Close()
+	c.notify(iface.ConnReconnecting, cause)
+
+	backoff := core.Backoff{Min: c.options.ReconnectMin, Max: c.options.ReconnectMax}
+	var err error
+	for attempt := 0; c.options.MaxRetries == 0 || attempt < c.options.MaxRetries; attempt++ {
+		select {
+		case <-time.After(backoff.Duration(attempt)):
+		case <-c.closed.Done():
+			return errors.New("client is closed")
+		}
+		var conn net.Conn
+		if conn, err = c.dial(); err != nil {
+			log.Warnf("reconnect to %s failed - %v", c.dc.Address, err)
+			continue
+		}
+		c.Lock()
+		if c.closed.HasFired() {
+			c.Unlock()
+			_ = conn.Close()
+			return errors.New("client is closed")
+		}
+		c.conn = conn
+		c.flate = isCompressed(conn)
+		c.Unlock()
+		c.startHeartbeat(conn)
+		log.Infof("reconnected to %s", c.dc.Address)
+		c.notify(iface.ConnConnected, nil)
+		return nil
 	}
-	if frame.Header.OpCode == ws.OpClose {
-		return nil, errors.New("the connection is closed")
+	c.notify(iface.ConnDisconnected, err)
+	return fmt.Errorf("reconnect to %s failed: %v", c.dc.Address, err)
+}
+
+func (c *Client) notify(state iface.ConnState, err error) {
+	atomic.StoreInt32(&c.state, int32(state))
+	if c.options.OnStateChange != nil {
+		c.options.OnStateChange(state, err)
 	}
-	return &Frame{raw: frame}, nil
+}
+
+// RTT 最近一次心跳的往返时间，没有开启心跳或还没有收到pong时为0
+func (c *Client) RTT() time.Duration {
+	return c.pings.RTT()
+}
+
+// State 返回当前的连接状态
+func (c *Client) State() iface.ConnState {
+	return iface.ConnState(atomic.LoadInt32(&c.state))
 }
 
 func (c *Client) Send(data []byte) error {
-	if atomic.LoadInt32(&c.state) == 0 {
-		return fmt.Errorf("conn is nil")
+	if c.State() != iface.ConnConnected {
+		return fmt.Errorf("conn is not connected")
 	}
 	c.Lock()
 	defer c.Unlock()
 	if c.options.WriteWait > 0 {
 		_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	}
+	if c.flate {
+		f, err := compressFrame(ws.NewBinaryFrame(data), c.options.CompressThreshold)
+		if err != nil {
+			return err
+		}
+		return ws.WriteFrame(c.conn, ws.MaskFrameInPlace(f))
+	}
 	return wsutil.WriteClientMessage(c.conn, ws.OpBinary, data)
 }
 
 func (c *Client) Close() {
 	c.once.Do(func() {
-		if c.conn == nil {
+		c.closed.Fire()
+		// 与Send、ping持有同一个锁，写超时避免对端不读取时阻塞
+		c.Lock()
+		conn := c.conn
+		if conn != nil {
+			_ = conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			_ = wsutil.WriteClientMessage(conn, ws.OpClose, nil)
+		}
+		c.Unlock()
+		if conn == nil {
 			return
 		}
+		conn.Close()
+		c.notify(iface.ConnClosed, nil)
+	})
+}
 
-		wsutil.WriteClientMessage(c.conn, ws.OpClose, nil)
+// closeIfTooLarge 消息帧超过最大长度时以StatusMessageTooBig关闭连接
+func (c *Client) closeIfTooLarge(err error) bool {
+	var tooLarge *iface.FrameTooLargeError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	c.closeWithReason(ws.StatusMessageTooBig, err.Error())
+	return true
+}
+
+// closeWithReason 发送带有状态码和原因的OpClose后关闭连接
+func (c *Client) closeWithReason(code ws.StatusCode, reason string) {
+	c.once.Do(func() {
+		c.closed.Fire()
+		c.Lock()
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+		_ = wsutil.WriteClientMessage(c.conn, ws.OpClose, ws.NewCloseFrameBody(code, reason))
+		c.Unlock()
 		c.conn.Close()
-		atomic.CompareAndSwapInt32(&c.state, 1, 0)
+		c.notify(iface.ConnClosed, nil)
 	})
 }
 
@@ -167,9 +353,16 @@ func (c *Client) SetDialer(dialer iface.IDialer) {
 	c.IDialer = dialer
 }
 
+// heartloop 定时发送ping，连续MaxMissedPongs次没有收到pong时关闭连接，
+// 使Read返回错误(或触发重连)，用于发现半开的连接
 func (c *Client) heartloop(conn net.Conn) error {
 	tick := time.NewTicker(c.options.Heartbeat)
+	defer tick.Stop()
 	for range tick.C {
+		if missed := c.pings.Outstanding(); missed >= c.options.MaxMissedPongs {
+			_ = conn.Close()
+			return fmt.Errorf("%d pongs missed, peer is dead", missed)
+		}
 		if err := c.ping(conn); err != nil {
 			return err
 		}
@@ -177,13 +370,19 @@ func (c *Client) heartloop(conn net.Conn) error {
 	return nil
 }
 
+// ping 与Send、Close持有同一个锁，避免同时写入连接；重连之后旧连接的心跳退出
 func (c *Client) ping(conn net.Conn) error {
 	c.Lock()
 	defer c.Unlock()
+	if c.conn != conn {
+		return errors.New("connection is replaced")
+	}
 	err := conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	if err != nil {
 		return err
 	}
 	logger.Tracef("%s send ping to server", c.id)
+	// 在写入之前记录，pong可能在WriteClientMessage返回之前到达
+	c.pings.Ping()
 	return wsutil.WriteClientMessage(conn, ws.OpCode(iface.OpPing), nil)
 }
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"im/iface"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeDialer 返回net.Pipe的一端，另一端不读取，模拟停止读取的对端
+type pipeDialer struct {
+	peer chan net.Conn
+}
+
+func (d *pipeDialer) DialAndHandshake(iface.DialerContext) (net.Conn, error) {
+	a, b := net.Pipe()
+	d.peer <- b
+	return a, nil
+}
+
+func TestClientCloseStalledPeer(t *testing.T) {
+	d := &pipeDialer{peer: make(chan net.Conn, 1)}
+	cli := NewClient("c1", "test", ClientOptions{WriteWait: time.Millisecond * 50})
+	cli.SetDialer(d)
+	assert.Nil(t, cli.Connect("ws://localhost:8000"))
+	peer := <-d.peer
+	defer peer.Close()
+
+	done := make(chan struct{})
+	go func() {
+		cli.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on a stalled peer")
+	}
+	assert.Equal(t, iface.ConnClosed, cli.(*Client).State())
+}
+
+// 重连之后旧连接的心跳不能再写入
+func TestClientPingReplacedConn(t *testing.T) {
+	d := &pipeDialer{peer: make(chan net.Conn, 2)}
+	cli := NewClient("c1", "test", ClientOptions{}).(*Client)
+	cli.SetDialer(d)
+	assert.Nil(t, cli.Connect("ws://localhost:8000"))
+	old := cli.conn
+	defer (<-d.peer).Close()
+
+	conn, err := cli.dial()
+	assert.Nil(t, err)
+	defer (<-d.peer).Close()
+	cli.Lock()
+	cli.conn = conn
+	cli.Unlock()
+
+	assert.EqualError(t, cli.ping(old), "connection is replaced")
+	assert.Equal(t, 0, cli.pings.Outstanding())
+}
+
+// pong在ping的写入返回之前到达时，不会被当作丢失的pong
+func TestClientPongBeforeWriteReturns(t *testing.T) {
+	d := &pipeDialer{peer: make(chan net.Conn, 1)}
+	cli := NewClient("c1", "test", ClientOptions{}).(*Client)
+	cli.SetDialer(d)
+	assert.Nil(t, cli.Connect("ws://localhost:8000"))
+	peer := <-d.peer
+	defer peer.Close()
+
+	// 对端读取到ping时，ping已经被记录，此时收到pong
+	go func() {
+		buf := make([]byte, 64)
+		_, _ = peer.Read(buf)
+		assert.Equal(t, 1, cli.pings.Outstanding())
+		cli.pings.Pong()
+		_, _ = peer.Read(buf)
+	}()
+	assert.Nil(t, cli.ping(cli.conn))
+	assert.Equal(t, 0, cli.pings.Outstanding())
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"im/iface"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// DefaultCompressThreshold 小于该长度的消息不压缩
+const DefaultCompressThreshold = 512
+
+// flateConn 标记握手时已经协商了permessage-deflate的连接
+type flateConn struct {
+	net.Conn
+}
+
+// Dial 建立websocket连接，dc.Compression为true时协商permessage-deflate
+func Dial(dc iface.DialerContext) (net.Conn, error) {
+	dialer := ws.Dialer{
+		Timeout:   dc.Timeout,
+		TLSConfig: dc.TLSConfig,
+	}
+	if dc.Compression {
+		dialer.Extensions = append(dialer.Extensions, wsflate.DefaultParameters.Option())
+	}
+	conn, _, hs, err := dialer.Dial(context.TODO(), dc.Address)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range hs.Extensions {
+		if bytes.Equal(ext.Name, wsflate.ExtensionNameBytes) {
+			return &flateConn{Conn: conn}, nil
+		}
+	}
+	return conn, nil
+}
+
+func isCompressed(conn net.Conn) bool {
+	_, ok := conn.(*flateConn)
+	return ok
+}
+
+// compressFrame 压缩数据帧，小于threshold时原样返回
+func compressFrame(f ws.Frame, threshold int) (ws.Frame, error) {
+	if !f.Header.OpCode.IsData() || len(f.Payload) < threshold {
+		return f, nil
+	}
+	raw := len(f.Payload)
+	payload, err := deflate(f.Payload)
+	if err != nil {
+		return f, err
+	}
+	if f.Header, err = wsflate.SetBit(f.Header); err != nil {
+		return f, err
+	}
+	f.Payload = payload
+	f.Header.Length = int64(len(payload))
+	observeCompression("write", raw, len(payload))
+	return f, nil
+}
+
+// decompressFrame 解压带有压缩标识的帧，返回的帧已经去掉掩码。
+// 解压后的长度同样受maxPayload限制，避免很小的帧解压出巨大的数据
+func decompressFrame(f ws.Frame, maxPayload int) (ws.Frame, error) {
+	compressed, err := wsflate.IsCompressed(f.Header)
+	if err != nil || !compressed {
+		return f, err
+	}
+	if f.Header.Masked {
+		f = ws.UnmaskFrameInPlace(f)
+	}
+	raw := len(f.Payload)
+	payload, err := inflate(f.Payload, maxPayload)
+	if err != nil {
+		return f, err
+	}
+	if f.Header, _, err = wsflate.UnsetBit(f.Header); err != nil {
+		return f, err
+	}
+	f.Payload = payload
+	f.Header.Length = int64(len(payload))
+	observeCompression("read", len(payload), raw)
+	return f, nil
+}
+
+// deflateTail 同步刷新后的结束标识，按RFC 7692在发送前去掉
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflate 压缩一条消息，双方均不保留上下文(no_context_takeover)
+func deflate(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(p); err != nil {
+		return nil, err
+	}
+	if err = fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// inflate 解压一条消息，补回结束标识并追加一个空的final块。maxPayload不大于0时不限制长度
+func inflate(p []byte, maxPayload int) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(
+		bytes.NewReader(p),
+		bytes.NewReader(deflateTail),
+		bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}),
+	))
+	defer fr.Close()
+	if maxPayload <= 0 {
+		return ioutil.ReadAll(fr)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(fr, int64(maxPayload)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxPayload {
+		return nil, &iface.FrameTooLargeError{Size: uint64(len(buf)), Max: maxPayload}
+	}
+	return buf, nil
+}
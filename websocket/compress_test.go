@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"im/iface"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressFrame(t *testing.T) {
+	payload := bytes.Repeat([]byte("hello"), 200)
+	f, err := compressFrame(ws.NewBinaryFrame(payload), 0)
+	assert.Nil(t, err)
+	assert.Less(t, len(f.Payload), len(payload))
+
+	f, err = decompressFrame(f, len(payload))
+	assert.Nil(t, err)
+	assert.Equal(t, payload, f.Payload)
+}
+
+func TestDecompressFrameTooLarge(t *testing.T) {
+	// 1MB的0压缩后只有1KB左右
+	payload := make([]byte, 1024*1024)
+	f, err := compressFrame(ws.NewBinaryFrame(payload), 0)
+	assert.Nil(t, err)
+	assert.Less(t, len(f.Payload), 4096)
+
+	_, err = decompressFrame(f, 4096)
+	var tooLarge *iface.FrameTooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, 4096, tooLarge.Max)
+}
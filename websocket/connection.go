@@ -1,14 +1,26 @@
 package websocket
 
 import (
+	"bufio"
 	"im/iface"
+	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/gobwas/ws"
 )
 
 type WsConn struct {
 	net.Conn
+	compress   bool
+	threshold  int
+	maxPayload int
+	remote     net.Addr //经过代理时为客户端的真实地址
+	identity   string   //升级前鉴权得到的账号
+
+	wmu sync.Mutex
+	wr  *bufio.Writer //不为空时WriteFrame只写入缓冲，由Flush发送
 }
 
 func NewConn(conn net.Conn) *WsConn {
@@ -17,19 +29,122 @@ func NewConn(conn net.Conn) *WsConn {
 	}
 }
 
+// NewConnWithCompression 握手时已协商permessage-deflate，大于threshold的消息压缩发送
+func NewConnWithCompression(conn net.Conn, threshold int) *WsConn {
+	return &WsConn{
+		Conn:      conn,
+		compress:  true,
+		threshold: threshold,
+	}
+}
+
+// SetMaxPayload 读取超过size的消息帧时返回FrameTooLargeError
+func (c *WsConn) SetMaxPayload(size int) {
+	c.maxPayload = size
+}
+
+// SetWriteBuffer 开启大小为size的写缓冲，之后写入的消息帧需要调用Flush才会发送，
+// size不大于0时关闭写缓冲。需要在连接开始写入之前调用
+func (c *WsConn) SetWriteBuffer(size int) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if size > 0 {
+		c.wr = bufio.NewWriterSize(c.Conn, size)
+	} else {
+		c.wr = nil
+	}
+}
+
+// Identity 升级前鉴权得到的账号，没有鉴权时为空
+func (c *WsConn) Identity() string {
+	return c.identity
+}
+
+// RemoteAddr 经过受信任的代理时返回客户端的真实地址
+func (c *WsConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
 func (c *WsConn) ReadFrame() (iface.IFrame, error) {
-	f, err := ws.ReadFrame(c.Conn)
+	f, err := readFrame(c.Conn, c.maxPayload)
 	if err != nil {
 		return nil, err
 	}
+	if c.compress {
+		if f, err = decompressFrame(f, c.maxPayload); err != nil {
+			return nil, err
+		}
+	}
 	return &Frame{raw: f}, nil
 }
 
 func (c *WsConn) WriteFrame(opcode iface.OpCode, data []byte) error {
 	f := ws.NewFrame(ws.OpCode(opcode), true, data)
+	if c.compress {
+		var err error
+		if f, err = compressFrame(f, c.threshold); err != nil {
+			return err
+		}
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.wr != nil {
+		return ws.WriteFrame(c.wr, f)
+	}
 	return ws.WriteFrame(c.Conn, f)
 }
 
+// maxCloseReason 控制帧的payload不能超过125字节，去掉2字节的状态码
+const maxCloseReason = 123
+
+// WriteClose 写入带有状态码与原因的关闭帧
+func (c *WsConn) WriteClose(code uint16, reason string) error {
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	return c.WriteFrame(iface.OpClose, ws.NewCloseFrameBody(ws.StatusCode(code), reason))
+}
+
+// Flush 发送写缓冲中的消息帧
 func (c *WsConn) Flush() error {
-	return nil
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.wr == nil {
+		return nil
+	}
+	return c.wr.Flush()
+}
+
+// closeFlushWait 关闭连接时发送剩余缓冲的超时时间，避免对端不读取时阻塞
+const closeFlushWait = time.Second
+
+// Close 关闭之前尽量发送写缓冲中的数据
+func (c *WsConn) Close() error {
+	c.wmu.Lock()
+	if c.wr != nil && c.wr.Buffered() > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(closeFlushWait))
+		_ = c.wr.Flush()
+	}
+	c.wmu.Unlock()
+	return c.Conn.Close()
+}
+
+// readFrame 与ws.ReadFrame相同，但在分配内存之前检查长度
+func readFrame(r io.Reader, maxPayload int) (f ws.Frame, err error) {
+	f.Header, err = ws.ReadHeader(r)
+	if err != nil {
+		return
+	}
+	if maxPayload > 0 && f.Header.Length > int64(maxPayload) {
+		err = &iface.FrameTooLargeError{Size: uint64(f.Header.Length), Max: maxPayload}
+		return
+	}
+	if f.Header.Length > 0 {
+		f.Payload = make([]byte, int(f.Header.Length))
+		_, err = io.ReadFull(r, f.Payload)
+	}
+	return
 }
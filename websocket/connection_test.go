@@ -0,0 +1,45 @@
+package websocket
+
+import (
+	"im/core"
+	"im/iface"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopListener struct{}
+
+func (nopListener) Receive(iface.IAgent, []byte) {}
+
+func TestReadloopFrameTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConn(server)
+	conn.SetMaxPayload(16)
+	ch := core.NewChannel("ch1", conn)
+	done := make(chan error, 1)
+	go func() {
+		done <- ch.Readloop(nopListener{})
+	}()
+
+	go func() {
+		_ = wsutil.WriteClientMessage(client, ws.OpBinary, make([]byte, 32))
+	}()
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	f, err := ws.ReadFrame(client)
+	assert.Nil(t, err)
+	assert.Equal(t, ws.OpClose, f.Header.OpCode)
+	code, reason := ws.ParseCloseFrameData(f.Payload)
+	assert.Equal(t, ws.StatusMessageTooBig, code)
+	assert.Contains(t, reason, "frame too large")
+
+	var tooLarge *iface.FrameTooLargeError
+	assert.ErrorAs(t, <-done, &tooLarge)
+	ch.Close()
+}
@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var compressRawBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kim",
+	Name:      "ws_compress_raw_bytes",
+	Help:      "压缩前的消息字节数",
+}, []string{"direction"})
+
+var compressedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kim",
+	Name:      "ws_compressed_bytes",
+	Help:      "压缩后的消息字节数",
+}, []string{"direction"})
+
+var compressRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "kim",
+	Name:      "ws_compress_ratio",
+	Help:      "压缩后与压缩前的字节比例",
+	Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+}, []string{"direction"})
+
+func observeCompression(direction string, raw, compressed int) {
+	if raw == 0 {
+		return
+	}
+	compressRawBytes.WithLabelValues(direction).Add(float64(raw))
+	compressedBytes.WithLabelValues(direction).Add(float64(compressed))
+	compressRatio.WithLabelValues(direction).Observe(float64(compressed) / float64(raw))
+}
@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"im/core"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// realIP 对端在trusted中时，依次从X-Forwarded-For、X-Real-IP中读取客户端ip
+func realIP(r *http.Request, trusted core.TrustedProxies) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !trusted.Contains(peer) {
+		return peer
+	}
+	// 从右往左跳过受信任的代理，第一个不受信任的地址即为客户端
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if !trusted.Contains(ip) || i == 0 {
+				return ip
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return peer
+}
@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"im/core"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := core.ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.Nil(t, err)
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"untrusted peer", "1.1.1.1:1000", []string{"2.2.2.2"}, "3.3.3.3", "1.1.1.1"},
+		{"no header", "10.0.0.1:1000", nil, "", "10.0.0.1"},
+		{"single hop", "10.0.0.1:1000", []string{"2.2.2.2"}, "", "2.2.2.2"},
+		{"trusted hops", "10.0.0.1:1000", []string{"2.2.2.2, 192.168.1.1, 10.0.0.2"}, "", "2.2.2.2"},
+		{"spoofed left hop", "10.0.0.1:1000", []string{"6.6.6.6, 2.2.2.2, 10.0.0.2"}, "", "2.2.2.2"},
+		{"untrusted middle hop", "10.0.0.1:1000", []string{"2.2.2.2, 5.5.5.5, 10.0.0.2"}, "", "5.5.5.5"},
+		{"multiple headers", "10.0.0.1:1000", []string{"2.2.2.2", "10.0.0.2"}, "", "2.2.2.2"},
+		{"all trusted", "10.0.0.1:1000", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"invalid hop", "10.0.0.1:1000", []string{"2.2.2.2, unknown"}, "3.3.3.3", "3.3.3.3"},
+		{"ipv6 hop", "10.0.0.1:1000", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"real ip", "10.0.0.1:1000", nil, "3.3.3.3", "3.3.3.3"},
+		{"invalid real ip", "10.0.0.1:1000", nil, "unknown", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, realIP(r, trusted))
+		})
+	}
+}
@@ -2,23 +2,102 @@ package websocket
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"im/core"
 	"im/iface"
 	"im/logger"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
 	"github.com/pkg/errors"
 	"github.com/segmentio/ksuid"
 )
 
 type ServerOptions struct {
-	loginwait time.Duration //登陆超时
-	readwait  time.Duration //读超时
-	writewait time.Duration //写超时
+	loginwait  time.Duration       //登陆超时
+	readwait   time.Duration       //读超时
+	writewait  time.Duration       //写超时
+	tlsConfig  *tls.Config         //不为空时以wss提供服务
+	path       string              //升级路径
+	origins    []string            //允许的Origin，为空时不校验
+	auth       AuthFunc            //升级前鉴权
+	compress   bool                //是否支持permessage-deflate
+	threshold  int                 //压缩阈值
+	maxPayload int                 //消息帧最大长度
+	limiter    *core.ConnLimiter   //连接数限制，多个server可以共用
+	trusted    core.TrustedProxies //受信任的代理，用于获取客户端的真实ip
+	writeBuf   int                 //写缓冲大小，为0时不使用缓冲
+}
+
+type Option func(opts *ServerOptions)
+
+// WithPath 设置websocket升级的路径，默认为 /
+func WithPath(path string) Option {
+	return func(opts *ServerOptions) {
+		opts.path = path
+	}
+}
+
+// WithAllowedOrigins 设置允许的Origin，* 表示不限制
+func WithAllowedOrigins(origins ...string) Option {
+	return func(opts *ServerOptions) {
+		opts.origins = origins
+	}
+}
+
+// WithAuth 设置升级前的鉴权函数
+func WithAuth(auth AuthFunc) Option {
+	return func(opts *ServerOptions) {
+		opts.auth = auth
+	}
+}
+
+// WithCompression 开启permessage-deflate，大于threshold字节的消息才压缩
+func WithCompression(threshold int) Option {
+	return func(opts *ServerOptions) {
+		opts.compress = true
+		opts.threshold = threshold
+	}
+}
+
+// WithMaxPayload 设置消息帧的最大长度
+func WithMaxPayload(size int) Option {
+	return func(opts *ServerOptions) {
+		opts.maxPayload = size
+	}
+}
+
+// WithConnLimiter 设置连接数限制，超过限制的请求在升级之前被拒绝
+func WithConnLimiter(limiter *core.ConnLimiter) Option {
+	return func(opts *ServerOptions) {
+		opts.limiter = limiter
+	}
+}
+
+// WithTrustedProxies 来自trusted的请求使用X-Forwarded-For或X-Real-IP作为客户端ip
+func WithTrustedProxies(trusted core.TrustedProxies) Option {
+	return func(opts *ServerOptions) {
+		opts.trusted = trusted
+	}
+}
+
+// WithWriteBuffer 设置连接的写缓冲大小，为0时每个消息帧直接写入
+func WithWriteBuffer(size int) Option {
+	return func(opts *ServerOptions) {
+		opts.writeBuf = size
+	}
+}
+
+// WithTLSConfig 开启tls监听
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(opts *ServerOptions) {
+		opts.tlsConfig = cfg
+	}
 }
 
 type Server struct {
@@ -30,20 +109,39 @@ type Server struct {
 	MessageListener iface.IMessageListener
 	Statelistener   iface.IStatelistener
 	once            sync.Once
+	quit            iface.IEvent
+	lmu             sync.Mutex //保护srv，Shutdown可能在Start开始监听之前调用
+	srv             *http.Server
 	options         ServerOptions
 }
 
 // NewServer NewServer
-func NewServer(listen string, service iface.ServiceRegistration) iface.IServer {
-	return &Server{
+func NewServer(listen string, service iface.ServiceRegistration, opts ...Option) iface.IServer {
+	srv := &Server{
 		listen:              listen,
 		ServiceRegistration: service,
+		quit:                core.NewEvent(),
 		options: ServerOptions{
-			loginwait: iface.DefaultLoginWait,
-			readwait:  iface.DefaultReadWait,
-			writewait: time.Second * 10,
+			loginwait:  iface.DefaultLoginWait,
+			readwait:   iface.DefaultReadWait,
+			writewait:  time.Second * 10,
+			path:       "/",
+			maxPayload: iface.DefaultMaxPayload,
+			writeBuf:   iface.DefaultWriteBuffer,
 		},
 	}
+	for _, opt := range opts {
+		opt(&srv.options)
+	}
+	if srv.options.limiter == nil {
+		srv.options.limiter = core.NewConnLimiter("ws", core.ConnLimits{})
+	}
+	return srv
+}
+
+// ConnStats 返回当前的连接计数
+func (s *Server) ConnStats() core.ConnStats {
+	return s.options.limiter.Stats()
 }
 
 func (s *Server) Start() error {
@@ -64,38 +162,28 @@ func (s *Server) Start() error {
 		s.ChannelMap = core.NewChannels(100)
 	}
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		raw, _, _, err := ws.UpgradeHTTP(r, w)
-		if err != nil {
+	mux.HandleFunc(s.options.path, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != s.options.path {
+			resp(w, http.StatusNotFound, "")
 			return
 		}
-
-		//包装conn
-		conn := NewConn(raw)
-		//鉴权
-		id, err := s.Acceptor.Accept(conn, s.options.loginwait)
-		if err != nil {
-			fmt.Println("认证失败：", err)
-			_ = conn.WriteFrame(iface.OpClose, []byte(err.Error()))
-			conn.Close()
-			fmt.Println("尝试关闭链接")
+		ip := realIP(r, s.options.trusted)
+		if err := s.options.limiter.Acquire(ip); err != nil {
+			status := http.StatusServiceUnavailable
+			if err == core.ErrTooManyConnsPerIP {
+				status = http.StatusTooManyRequests
+			}
+			resp(w, status, err.Error())
 			return
 		}
-
-		if _, ok := s.ChannelMap.Get(id); ok {
-			log.Warnf("channel %s existed", id)
-			_ = conn.WriteFrame(iface.OpClose, []byte("channelId is repeated"))
-			conn.Close()
+		channel, ok := s.handshake(w, r, ip)
+		s.options.limiter.HandshakeDone()
+		if !ok {
+			s.options.limiter.Release(ip)
 			return
 		}
-
-		// step 4
-		channel := core.NewChannel(id, conn)
-		channel.SetWriteWait(s.options.writewait)
-		channel.SetReadWait(s.options.readwait)
-		s.ChannelMap.Add(channel)
 		go func(channel iface.IChannel) {
-
+			defer s.options.limiter.Release(ip)
 			err := channel.Readloop(s.MessageListener)
 			if err != nil {
 				log.Info(err)
@@ -108,7 +196,102 @@ func (s *Server) Start() error {
 			channel.Close()
 		}(channel)
 	})
-	return http.ListenAndServe(s.listen, mux)
+	lis, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	if s.options.tlsConfig != nil {
+		lis = tls.NewListener(lis, s.options.tlsConfig)
+	}
+	s.lmu.Lock()
+	if s.quit.HasFired() {
+		s.lmu.Unlock()
+		_ = lis.Close()
+		return nil
+	}
+	s.srv = &http.Server{Handler: mux}
+	s.lmu.Unlock()
+	log.Infof("ws server started on %s", s.listen)
+	err = s.srv.Serve(lis)
+	// Shutdown关闭了监听
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// handshake 完成鉴权、升级与登录，成功时返回已加入ChannelMap的channel
+func (s *Server) handshake(w http.ResponseWriter, r *http.Request, ip string) (iface.IChannel, bool) {
+	log := logger.WithFields(logger.Fields{
+		"module": "ws.server",
+		"id":     s.ServiceID(),
+	})
+	if !checkOrigin(r, s.options.origins) {
+		resp(w, http.StatusForbidden, "origin not allowed")
+		return nil, false
+	}
+	var identity string
+	if s.options.auth != nil {
+		var status int
+		var err error
+		identity, status, err = s.options.auth(r, TokenFromRequest(r))
+		if err != nil {
+			if status == 0 {
+				status = http.StatusUnauthorized
+			}
+			resp(w, status, err.Error())
+			return nil, false
+		}
+	}
+	upgrader := ws.HTTPUpgrader{
+		Protocol: func(p string) bool {
+			return p == TokenProtocol
+		},
+	}
+	var ext *wsflate.Extension
+	if s.options.compress {
+		ext = &wsflate.Extension{Parameters: wsflate.DefaultParameters}
+		upgrader.Negotiate = ext.Negotiate
+	}
+	raw, _, _, err := upgrader.Upgrade(r, w)
+	if err != nil {
+		return nil, false
+	}
+
+	//包装conn
+	conn := NewConn(raw)
+	if ext != nil {
+		if _, accepted := ext.Accepted(); accepted {
+			conn = NewConnWithCompression(raw, s.options.threshold)
+		}
+	}
+	conn.identity = identity
+	conn.SetMaxPayload(s.options.maxPayload)
+	conn.SetWriteBuffer(s.options.writeBuf)
+	if ip != core.RemoteIP(raw.RemoteAddr()) {
+		conn.remote = &net.TCPAddr{IP: net.ParseIP(ip)}
+	}
+	//鉴权
+	id, err := s.Acceptor.Accept(conn, s.options.loginwait)
+	if err != nil {
+		log.WithField("channel", id).WithField("ip", ip).Warnf("accept failed: %v", err)
+		_ = conn.WriteFrame(iface.OpClose, []byte(err.Error()))
+		conn.Close()
+		return nil, false
+	}
+
+	if _, ok := s.ChannelMap.Get(id); ok {
+		log.Warnf("channel %s existed", id)
+		_ = conn.WriteFrame(iface.OpClose, []byte("channelId is repeated"))
+		conn.Close()
+		return nil, false
+	}
+
+	channel := core.NewChannel(id, conn)
+	channel.SetWriteWait(s.options.writewait)
+	channel.SetReadWait(s.options.readwait)
+	s.ChannelMap.Add(channel)
+	return channel, true
 }
 
 func (s *Server) Push(id string, data []byte) error {
@@ -129,6 +312,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		defer func() {
 			log.Infoln("shutdown")
 		}()
+		// 关闭监听，升级之后的连接不受http.Server管理，在下面逐个关闭
+		s.lmu.Lock()
+		s.quit.Fire()
+		if s.srv != nil {
+			_ = s.srv.Close()
+		}
+		s.lmu.Unlock()
+		if s.ChannelMap == nil {
+			return
+		}
 		channels := s.ChannelMap.All()
 		for _, ch := range channels {
 			ch.Close()
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"im/core"
+	"im/iface"
+	"im/naming"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 证书与tcp的tls测试共用core/testdata
+const certDir = "../core/testdata/"
+
+type echoListener struct{}
+
+func (echoListener) Receive(ag iface.IAgent, payload []byte) { _ = ag.Push(payload) }
+
+func (echoListener) Disconnect(string) error { return nil }
+
+type wsDialer struct{}
+
+func (wsDialer) DialAndHandshake(ctx iface.DialerContext) (net.Conn, error) {
+	return Dial(ctx)
+}
+
+// tlsRoundTrip 通过wss发送一个消息并等待服务端原样返回
+func tlsRoundTrip(t *testing.T, srvConfig, cliConfig *tls.Config) error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := lis.Addr().String()
+	_ = lis.Close()
+
+	srv := NewServer(addr, naming.NewEntry("s1", "test", "ws", "127.0.0.1", 0), WithTLSConfig(srvConfig))
+	srv.SetStateListener(echoListener{})
+	srv.SetMessageListener(echoListener{})
+	go func() {
+		_ = srv.Start()
+	}()
+	defer srv.Shutdown(context.Background())
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+
+	_, port, _ := net.SplitHostPort(addr)
+	cli := NewClient("c1", "test", ClientOptions{TLSConfig: cliConfig})
+	cli.SetDialer(wsDialer{})
+	if err := cli.Connect("wss://localhost:" + port + "/"); err != nil {
+		return err
+	}
+	defer cli.Close()
+	if err := cli.Send([]byte("hello")); err != nil {
+		return err
+	}
+	// tls1.3中服务端在握手之后才校验客户端证书，失败在读取时返回
+	result := make(chan error, 1)
+	go func() {
+		frame, err := cli.Read()
+		if err == nil && string(frame.GetPayload()) != "hello" {
+			err = errors.New("unexpected payload")
+		}
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second * 3):
+		return errors.New("read timeout")
+	}
+}
+
+func TestTLSRoundTrip(t *testing.T) {
+	server, err := core.NewServerTLSConfig(certDir+"server.pem", certDir+"server-key.pem", "")
+	assert.Nil(t, err)
+	mutual, err := core.NewServerTLSConfig(certDir+"server.pem", certDir+"server-key.pem", certDir+"ca.pem")
+	assert.Nil(t, err)
+	anonymous, err := core.NewClientTLSConfig(certDir+"ca.pem", "", "")
+	assert.Nil(t, err)
+	client, err := core.NewClientTLSConfig(certDir+"ca.pem", certDir+"client.pem", certDir+"client-key.pem")
+	assert.Nil(t, err)
+	stranger, err := core.NewClientTLSConfig(certDir+"ca.pem", certDir+"stranger.pem", certDir+"stranger-key.pem")
+	assert.Nil(t, err)
+	untrusted, err := core.NewClientTLSConfig("", "", "")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name    string
+		server  *tls.Config
+		client  *tls.Config
+		wantErr bool
+	}{
+		{"server cert", server, anonymous, false},
+		{"untrusted server", server, untrusted, true},
+		{"client cert", mutual, client, false},
+		{"no client cert", mutual, anonymous, true},
+		{"client cert of other ca", mutual, stranger, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := tlsRoundTrip(t, c.server, c.client)
+			if c.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package device
+
+import (
+	"strings"
+
+	"github.com/klintcheng/kim/wire/pkt"
+)
+
+// TagPrefix 登录请求LoginReq.Tags中携带客户端设备类型的tag前缀，如"device:ios"。
+// 网关从中取出设备类型写入会话，逻辑服务按设备策略决定同一账号的会话能否同时在线
+const TagPrefix = "device:"
+
+// Tag 客户端登录时加入LoginReq.Tags的设备类型
+func Tag(device string) string {
+	return TagPrefix + device
+}
+
+// FromLogin 返回登录请求中的设备类型，没有时为空
+func FromLogin(req *pkt.LoginReq) string {
+	for _, tag := range req.GetTags() {
+		if strings.HasPrefix(tag, TagPrefix) {
+			return strings.TrimPrefix(tag, TagPrefix)
+		}
+	}
+	return ""
+}
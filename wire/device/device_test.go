@@ -0,0 +1,25 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/klintcheng/kim/wire/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"none", nil, ""},
+		{"device", []string{Tag("ios")}, "ios"},
+		{"other tags", []string{"vip", Tag("android"), Tag("ios")}, "android"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FromLogin(&pkt.LoginReq{Tags: tt.tags}))
+		})
+	}
+}
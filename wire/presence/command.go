@@ -0,0 +1,13 @@
+package presence
+
+// 在线状态相关的指令，由逻辑服务处理
+const (
+	// CommandQuery 查询账号的在线状态，请求QueryReq，响应QueryResp
+	CommandQuery = "chat.presence.query"
+	// CommandSubscribe 订阅账号的在线状态变化，请求SubscribeReq，响应QueryResp
+	CommandSubscribe = "chat.presence.subscribe"
+	// CommandUnsubscribe 退订，请求UnsubscribeReq
+	CommandUnsubscribe = "chat.presence.unsubscribe"
+	// CommandNotify 订阅的账号上线或下线时推送Status
+	CommandNotify = "chat.presence.notify"
+)